@@ -0,0 +1,430 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+// actionError is a failure of a game action which is caused by the
+// client. It is returned to the client as is. code is a stable name of
+// the failure for clients which show their own messages, if any.
+type actionError struct {
+	status  int
+	message string
+	code    string
+}
+
+func (e actionError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("status %d", e.status)
+	}
+	return e.message
+}
+
+func newActionError(status int, message string) error {
+	return actionError{status: status, message: message}
+}
+
+func newCodedActionError(status int, code string, message string) error {
+	return actionError{status: status, message: message, code: code}
+}
+
+// respondActionError reports the outcome of a failed action to a REST
+// client.
+func respondActionError(c *gin.Context, err error, context string) {
+	if aerr, ok := err.(actionError); ok {
+		if aerr.message == "" {
+			c.JSON(aerr.status, nil)
+			return
+		}
+		if aerr.code != "" {
+			c.JSON(aerr.status, gin.H{"error": aerr.message, "code": aerr.code})
+			return
+		}
+		c.JSON(aerr.status, gin.H{"error": aerr.message})
+		return
+	}
+	log.Printf("%s failed: %s", context, err)
+	c.AbortWithStatus(500)
+}
+
+// lockPlayerGame serializes an action with all others of the player's
+// game and reloads the player and the game, which may have changed in the
+// meantime.
+func lockPlayerGame(player *Player) (unlock func(), err error) {
+	unlock = lockGame(player.GameID)
+	err = db.First(player, player.ID).Error
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to reload player: %s", err)
+	}
+	err = db.First(&player.Game, player.GameID).Error
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("failed to reload game: %s", err)
+	}
+	return unlock, nil
+}
+
+// setPlayerReady marks the player as ready for the next round and starts
+// the round once everyone is ready.
+func setPlayerReady(player Player) error {
+	unlock, err := lockPlayerGame(&player)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if player.Game.Phase != GAME_PHASE_WAIT_FOR_READY {
+		return newActionError(403, "")
+	}
+	if player.Queued {
+		return newActionError(403, "waiting for next round")
+	}
+
+	player.Round = player.Game.Round
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// UpdateColumn avoids saving the (possibly outdated) associated game:
+		err := tx.Model(&player).UpdateColumn("round", player.Round).Error
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, player.Game, &player.ID, GAME_EVENT_PLAYER_READY, nil)
+	})
+	if err != nil {
+		return fmt.Errorf("markPlayerReady failed: %s", err)
+	}
+
+	// Once all players are ready, move to next round:
+	_, err = advancePhase(&player.Game)
+	if err != nil {
+		return fmt.Errorf("markPlayerReady failed to start new round: %s", err)
+	}
+	publishBoard(player.Game.ID)
+	return nil
+}
+
+// saveWord validates and saves the player's invented word. jokers lists
+// the positions of the word's letters for which a joker is played.
+func saveWord(player Player, submitted string, jokers []int) error {
+	unlock, err := lockPlayerGame(&player)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if player.Game.Phase != GAME_PHASE_SUBMIT_WORD {
+		return newActionError(403, "")
+	}
+	if player.Queued {
+		return newActionError(403, "waiting for next round")
+	}
+	var word Word
+	err = db.Model(player).Related(&word).Error
+	if err != nil {
+		return fmt.Errorf("failed to find word: %s", err)
+	}
+
+	tiles := parseTiles(word.Letters)
+	runes := []rune(submitted)
+	if len(runes) > len([]rune(strings.Join(tiles, ""))) {
+		return newActionError(400, "too many letters")
+	}
+
+	isJoker := make(map[int]bool, len(jokers))
+	for _, pos := range jokers {
+		if pos < 0 || pos >= len(runes) || isJoker[pos] || !unicode.IsLetter(runes[pos]) {
+			return newActionError(400, "invalid joker")
+		}
+		isJoker[pos] = true
+	}
+
+	// Each tile may be used once and multi-letter tiles can't be split:
+	if !matchTiles(runes, 0, isJoker, tiles) {
+		return newActionError(400, "invalid letter")
+	}
+	var card Card
+	err = db.First(&card, word.CardID).Error
+	if err != nil {
+		return fmt.Errorf("failed to find card: %s", err)
+	}
+	err = checkWordRules(player.Game.GameSettings, submitted, card.Text)
+	if err != nil {
+		return err
+	}
+
+	word.Word = submitted
+	word.Jokers = formatJokers(jokers)
+	word.JokerPenalty = 0
+	if player.Game.JokerPenalty {
+		word.JokerPenalty = len(jokers)
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(&word).Error
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, player.Game, &player.ID, GAME_EVENT_WORD_SUBMITTED, gameEventWordSubmitted{
+			Word:   submitted,
+			Jokers: jokers,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save word: %s", err)
+	}
+
+	_, err = advancePhase(&player.Game)
+	if err != nil {
+		return fmt.Errorf("submitWord failed to advance phase: %s", err)
+	}
+	publishBoard(player.Game.ID)
+	return nil
+}
+
+// getMulligansLeft returns how often the player may still redraw the
+// letters of the given word.
+func getMulligansLeft(tx *gorm.DB, player Player, word Word) (int, error) {
+	used := word.Redraws
+	if player.Game.MulliganScope == MULLIGAN_SCOPE_GAME {
+		var total struct {
+			Redraws int
+		}
+		q := tx.Table("words")
+		q = q.Select("COALESCE(SUM(redraws), 0) AS redraws")
+		q = q.Where("game_id = ? AND player_id = ?", player.GameID, player.ID)
+		err := q.Scan(&total).Error
+		if err != nil {
+			return 0, err
+		}
+		used = total.Redraws
+	}
+	if used >= player.Game.Mulligans {
+		return 0, nil
+	}
+	return player.Game.Mulligans - used, nil
+}
+
+// redrawLetters replaces the player's letters by new ones, as long as
+// the player has mulligans left and has not submitted a word yet.
+func redrawLetters(player Player) error {
+	unlock, err := lockPlayerGame(&player)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if player.Game.Phase != GAME_PHASE_SUBMIT_WORD {
+		return newActionError(403, "")
+	}
+	if player.Queued {
+		return newActionError(403, "waiting for next round")
+	}
+	var word Word
+	err = db.Model(player).Related(&word).Error
+	if err != nil {
+		return fmt.Errorf("failed to find word: %s", err)
+	}
+	if word.Word != "" {
+		return newActionError(403, "word already submitted")
+	}
+	left, err := getMulligansLeft(db, player, word)
+	if err != nil {
+		return fmt.Errorf("failed to count mulligans: %s", err)
+	}
+	if left == 0 {
+		return newActionError(403, "no mulligans left")
+	}
+
+	letters := pickRandomLetters(player.Game.GameSettings)
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&word).UpdateColumns(map[string]interface{}{
+			"letters": letters,
+			"redraws": gorm.Expr("redraws + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, player.Game, &player.ID, GAME_EVENT_LETTERS_REDRAWN, gameEventLettersRedrawn{
+			Letters: letters,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("failed to redraw letters: %s", err)
+	}
+
+	publishBoard(player.Game.ID)
+	publishMulligan(player)
+	return nil
+}
+
+// saveGuesses replaces the player's assignment of cards to the other
+// players' words.
+func saveGuesses(player Player, guesses jsonGuesses) error {
+	unlock, err := lockPlayerGame(&player)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if player.Game.Phase != GAME_PHASE_ASSIGN_WORDS {
+		return newActionError(403, "")
+	}
+	if player.Queued {
+		return newActionError(403, "waiting for next round")
+	}
+
+	var numOtherPlayers int
+	q := db.Table("players")
+	q = q.Where("game_id = ?", player.GameID)
+	q = q.Where("id <> ?", player.ID)
+	q = q.Where("queued = 0")
+	q = q.Count(&numOtherPlayers)
+	err = q.Error
+	if err != nil {
+		return fmt.Errorf("failed to get number of other players: %s", err)
+	}
+	if len(guesses) != numOtherPlayers {
+		return newActionError(400, "bad number of guesses")
+	}
+	for playerID, _ := range guesses {
+		if playerID == player.ID {
+			return newActionError(403, "attempting to guess own word")
+		}
+	}
+
+	errCardUsed := errors.New("card already used")
+	errInvalidCard := errors.New("invalid card")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(Guess{
+			GameID:   player.Game.ID,
+			Round:    player.Game.Round,
+			PlayerID: player.ID,
+		}).Delete(Guess{}).Error
+		if err != nil {
+			return err
+		}
+		usedCards := make(map[uint64]bool, 0)
+		for playerID, cardID := range guesses {
+			if _, exists := usedCards[cardID]; exists {
+				return errCardUsed
+			}
+			var word Word
+			err = tx.Where(Word{
+				GameID:   player.Game.ID,
+				Round:    player.Game.Round,
+				PlayerID: &playerID,
+			}).First(&word).Error
+			if err != nil {
+				return err
+			}
+
+			var validCard uint64
+			err = tx.Table("words").Where(&Word{
+				GameID: player.Game.ID,
+				Round:  player.Game.Round,
+				CardID: cardID,
+			}).Count(&validCard).Error
+			if err != nil {
+				return err
+			}
+			if validCard != 1 {
+				return errInvalidCard
+			}
+
+			err = tx.Save(&Guess{
+				GameID:   player.Game.ID,
+				Round:    player.Game.Round,
+				PlayerID: player.ID,
+				WordID:   word.ID,
+				CardID:   cardID,
+			}).Error
+			if err != nil {
+				return err
+			}
+			usedCards[cardID] = true
+		}
+		return appendGameEvent(tx, player.Game, &player.ID, GAME_EVENT_GUESSES_CHANGED, gameEventGuessesChanged{
+			Guesses: guesses,
+		})
+	})
+	if err == gorm.ErrRecordNotFound {
+		return newActionError(400, "player not resolvable to word")
+	}
+	if err == errInvalidCard {
+		return newActionError(400, err.Error())
+	}
+	if err == errCardUsed {
+		return newActionError(400, "duplicate card use")
+	}
+	if err != nil {
+		return fmt.Errorf("submitGuesses failed: %v", err)
+	}
+
+	_, err = advancePhase(&player.Game)
+	if err != nil {
+		return fmt.Errorf("submitGuesses failed to advance phase: %s", err)
+	}
+	publishBoard(player.Game.ID)
+	return nil
+}
+
+// scoreCurrentWord reveals the word which is currently being scored. Only
+// the word's author may do so. The round ends after the last word.
+func scoreCurrentWord(player Player) error {
+	unlock, err := lockPlayerGame(&player)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if player.Game.Phase != GAME_PHASE_SCORE {
+		return newActionError(403, "wrong game phase")
+	}
+	if player.Queued {
+		return newActionError(403, "waiting for next round")
+	}
+
+	word, err := getCurrentlyScoredWord(player.Game)
+	if err == gorm.ErrRecordNotFound {
+		return newActionError(403, "no scoring in progress")
+	}
+	if err != nil {
+		return fmt.Errorf("getCurrentlyScoredWord failed: %s", err)
+	}
+
+	if word.PlayerID != nil && *word.PlayerID != player.ID {
+		return newActionError(403, "not your turn")
+	}
+
+	word.IsScored = true
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Save(&word).Error
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, player.Game, &player.ID, GAME_EVENT_WORD_SCORED, gameEventWordScored{
+			CardID: word.CardID,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("saving word failed: %s", err)
+	}
+
+	// The round is over after the last word:
+	_, err = advancePhase(&player.Game)
+	if err != nil {
+		return fmt.Errorf("markScored failed to advance phase: %s", err)
+	}
+
+	publishBoard(player.Game.ID)
+	broker.Send(player.Game.ID, "scoreboard")
+	return nil
+}
@@ -1,112 +1,376 @@
 package main
 
 import (
+	"sync"
 	"time"
 )
 
-type brokerChannel struct {
-	id      uint64
-	channel chan string
+const (
+	// BROKER_QUEUE_SIZE limits the number of pending events per client.
+	// Rendered events with the same name are coalesced, while events
+	// with a payload from their sender are all kept. So this is only
+	// reached by clients which do not read at all.
+	BROKER_QUEUE_SIZE = 16
+	// BROKER_HISTORY_SIZE is the number of events per game which are
+	// kept for replaying them to reconnecting clients.
+	BROKER_HISTORY_SIZE = 64
+	// BROKER_HUB_IDLE_TIMEOUT is how long a game's events are kept
+	// after its last listener has left.
+	BROKER_HUB_IDLE_TIMEOUT = 5 * time.Minute
+)
+
+type brokerEvent struct {
+	id   uint64
+	name string
+	data string
+	// fixed is set if data was given by the sender instead of being
+	// rendered, so that it can't be replaced by a newer event.
+	fixed bool
+}
+
+// brokerHistoryEntry is a sent event along with the payloads which
+// were rendered for the listeners at that time.
+type brokerHistoryEntry struct {
+	id       uint64
+	name     string
+	data     string
+	rendered map[uint64]*brokerEvent
+}
+
+// EventRenderer computes the payload of an event for a listener. playerID
+// is 0 for listeners which aren't bound to a player. Returning false
+// suppresses the event for that listener.
+type EventRenderer func(id uint64, playerID uint64) (string, bool)
+
+// BrokerClient is a single listener with its own queue. Sending to a
+// client never blocks: a newer rendered event replaces a pending one with
+// the same name and the oldest event is dropped once the queue is full.
+type BrokerClient struct {
+	playerID uint64
+	mu       sync.Mutex
+	queue    []brokerEvent
+	notify   chan struct{}
+	dropped  uint64
+}
+
+func newBrokerClient(playerID uint64) *BrokerClient {
+	return &BrokerClient{
+		playerID: playerID,
+		notify:   make(chan struct{}, 1),
+	}
+}
+
+func (cl *BrokerClient) push(e brokerEvent) {
+	cl.mu.Lock()
+	for i, pending := range cl.queue {
+		if pending.name == e.name && !pending.fixed && !e.fixed {
+			// Coalesce, the newer event supersedes the pending one:
+			cl.queue = append(cl.queue[:i], cl.queue[i+1:]...)
+			break
+		}
+	}
+	cl.queue = append(cl.queue, e)
+	if len(cl.queue) > BROKER_QUEUE_SIZE {
+		cl.queue = cl.queue[1:]
+		cl.dropped++
+	}
+	cl.mu.Unlock()
+
+	select {
+	case cl.notify <- struct{}{}:
+	default:
+		// a wakeup is already pending
+	}
 }
 
-type brokerMessage struct {
-	id      uint64
-	message string
+// Notify returns a channel which receives a value whenever new events
+// are waiting to be fetched via Drain.
+func (cl *BrokerClient) Notify() <-chan struct{} {
+	return cl.notify
 }
 
-type Broker struct {
-	clients        map[uint64]map[chan string]bool
-	events         chan brokerMessage
-	newClients     chan brokerChannel
-	closingClients chan brokerChannel
+// Drain returns and removes all pending events.
+func (cl *BrokerClient) Drain() []brokerEvent {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	events := cl.queue
+	cl.queue = nil
+	return events
 }
 
-func NewBroker() *Broker {
-	b := &Broker{
-		events:         make(chan brokerMessage, 10),
-		newClients:     make(chan brokerChannel),
-		closingClients: make(chan brokerChannel),
-		clients:        make(map[uint64]map[chan string]bool),
+// brokerHub holds the listeners and recent events of a single game, so
+// that games don't interfere with each other.
+type brokerHub struct {
+	// sendMu keeps listeners from subscribing while an event is being
+	// sent, so that they get every event exactly once.
+	sendMu    sync.Mutex
+	mu        sync.Mutex
+	clients   map[*BrokerClient]bool
+	lastID    uint64
+	history   []brokerHistoryEntry
+	idleSince time.Time
+	// pending holds the events which are waiting for the hub's worker,
+	// working tells whether it is running.
+	pending []brokerEvent
+	working bool
+}
+
+func newBrokerHub() *brokerHub {
+	return &brokerHub{
+		clients: make(map[*BrokerClient]bool),
+		// Event ids are based on the time, so that they keep increasing
+		// even if a hub gets recreated or the server restarts.
+		lastID:    uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		idleSince: time.Now(),
 	}
+}
 
-	// Listen for events to distribute:
-	go b.listen()
+// Broker distributes events to the listeners of a game.
+type Broker interface {
+	SetRenderer(name string, r EventRenderer)
+	Subscribe(id uint64, playerID uint64, lastEventID uint64) (*BrokerClient, bool)
+	Unsubscribe(id uint64, cl *BrokerClient)
+	LastEventID(id uint64) uint64
+	Send(id uint64, message string)
+	SendData(id uint64, message string, data string)
+	Render(id uint64, playerID uint64, name string) (brokerEvent, bool)
+}
 
+// MemoryBroker is a Broker for a single server instance.
+type MemoryBroker struct {
+	mu        sync.RWMutex
+	hubs      map[uint64]*brokerHub
+	renderers map[string]EventRenderer
+}
+
+func NewMemoryBroker() *MemoryBroker {
+	b := &MemoryBroker{
+		hubs:      make(map[uint64]*brokerHub),
+		renderers: make(map[string]EventRenderer),
+	}
+	go b.expireHubs()
 	return b
 }
 
-// Listen on different channels and act accordingly
-func (b *Broker) listen() {
-	wait := time.Second * 1
-	removeClient := func(id uint64, channel chan string) {
-		if _, exists := b.clients[id]; !exists {
-			return
+// expireHubs forgets about games which haven't had listeners for a while.
+func (b *MemoryBroker) expireHubs() {
+	for range time.Tick(time.Minute) {
+		b.mu.Lock()
+		for id, hub := range b.hubs {
+			hub.mu.Lock()
+			expired := len(hub.clients) == 0 && time.Since(hub.idleSince) > BROKER_HUB_IDLE_TIMEOUT
+			hub.mu.Unlock()
+			if expired {
+				delete(b.hubs, id)
+			}
 		}
-		if _, exists := b.clients[id][channel]; !exists {
-			return
+		b.mu.Unlock()
+	}
+}
+
+// SetRenderer registers a function which computes the payload of all
+// events with the given name. Events without renderer have no payload.
+func (b *MemoryBroker) SetRenderer(name string, r EventRenderer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.renderers[name] = r
+}
+
+// Subscribe registers a new listener for the given id. Listeners which
+// are bound to a player get player-specific payloads. If lastEventID is
+// non-zero, the events sent after it are queued for the new listener right
+// away. false is returned if these are not available anymore, so that the
+// caller can send the current state instead.
+func (b *MemoryBroker) Subscribe(id uint64, playerID uint64, lastEventID uint64) (*BrokerClient, bool) {
+	cl := newBrokerClient(playerID)
+	b.mu.Lock()
+	hub, exists := b.hubs[id]
+	if !exists {
+		hub = newBrokerHub()
+		b.hubs[id] = hub
+	}
+	b.mu.Unlock()
+
+	hub.sendMu.Lock()
+	defer hub.sendMu.Unlock()
+	hub.mu.Lock()
+	hub.clients[cl] = true
+	lastID := hub.lastID
+	history := hub.history
+	hub.mu.Unlock()
+
+	if lastEventID == 0 {
+		return cl, false
+	}
+	if lastEventID > lastID {
+		// unknown to us, maybe sent before a restart:
+		return cl, false
+	}
+	if len(history) == 0 || lastEventID < history[0].id-1 {
+		return cl, lastEventID == lastID
+	}
+	for _, entry := range history {
+		if entry.id <= lastEventID {
+			continue
 		}
-		delete(b.clients[id], channel)
-		if len(b.clients[id]) == 0 {
-			delete(b.clients, id)
+		e, rendered := entry.rendered[playerID]
+		if !rendered && entry.data != "" {
+			e = &brokerEvent{name: entry.name, data: entry.data, fixed: true}
+		} else if !rendered {
+			// The listener wasn't around when the event was sent:
+			if r, ok := b.Render(id, playerID, entry.name); ok {
+				e = &r
+			}
 		}
-		close(channel)
+		if e != nil {
+			r := *e
+			r.id = entry.id
+			cl.push(r)
+		}
+	}
+	return cl, true
+}
+
+// Unsubscribe removes a listener. The game's recent events are kept for
+// a while in case the listener reconnects.
+func (b *MemoryBroker) Unsubscribe(id uint64, cl *BrokerClient) {
+	b.mu.RLock()
+	hub, exists := b.hubs[id]
+	b.mu.RUnlock()
+	if !exists {
+		return
+	}
+	hub.mu.Lock()
+	delete(hub.clients, cl)
+	if len(hub.clients) == 0 {
+		hub.idleSince = time.Now()
+	}
+	hub.mu.Unlock()
+}
+
+// LastEventID returns the id of the most recent event for the given id.
+func (b *MemoryBroker) LastEventID(id uint64) uint64 {
+	b.mu.RLock()
+	hub, exists := b.hubs[id]
+	b.mu.RUnlock()
+	if !exists {
+		return 0
+	}
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	return hub.lastID
+}
+
+// Send distributes an event to all listeners of the given id. It neither
+// blocks on slow listeners nor waits for the event to be rendered.
+func (b *MemoryBroker) Send(id uint64, message string) {
+	b.deliver(id, brokerEvent{name: message})
+}
+
+// SendData distributes an event with the same payload for all listeners
+// instead of rendering it. This is meant for events which tell what has
+// happened rather than what the current state is.
+func (b *MemoryBroker) SendData(id uint64, message string, data string) {
+	b.deliver(id, brokerEvent{name: message, data: data})
+}
+
+// deliver queues an event for the hub's worker, which renders and
+// distributes it in the background. Events of a game keep their order,
+// while slow renderers only hold up their own game.
+func (b *MemoryBroker) deliver(id uint64, e brokerEvent) {
+	b.mu.RLock()
+	hub, exists := b.hubs[id]
+	b.mu.RUnlock()
+	if !exists {
+		// an event for an id we don't know (maybe there are no live listeners).
+		// just ignore it:
+		return
 	}
+	hub.mu.Lock()
+	hub.pending = append(hub.pending, e)
+	start := !hub.working
+	hub.working = true
+	hub.mu.Unlock()
+	if start {
+		go b.work(id, hub)
+	}
+}
+
+// work sends the hub's pending events and stops once there are none left.
+func (b *MemoryBroker) work(id uint64, hub *brokerHub) {
 	for {
-		select {
-		case s := <-b.newClients:
-			// A new client has connected.
-			// Register their message channel
-			if _, exists := b.clients[s.id]; !exists {
-				b.clients[s.id] = make(map[chan string]bool)
-			}
-			b.clients[s.id][s.channel] = true
-
-		case s := <-b.closingClients:
-			// A client has detached and we want to
-			// stop sending them messages.
-			removeClient(s.id, s.channel)
-
-		case event := <-b.events:
-			// We got a new event from the outside!
-			// Send event to all connected clients
-			chans, exists := b.clients[event.id]
-			if !exists {
-				// an event for an id we don't know (maybe there are no live listeners).
-				// just ignore it:
-				continue
-			}
-			for clientChan, _ := range chans {
-				select {
-				case clientChan <- event.message:
-				case <-time.After(wait):
-					removeClient(event.id, clientChan)
-				}
-			}
+		hub.mu.Lock()
+		if len(hub.pending) == 0 {
+			hub.working = false
+			hub.mu.Unlock()
+			return
 		}
+		e := hub.pending[0]
+		hub.pending = hub.pending[1:]
+		hub.mu.Unlock()
+		b.send(id, hub, e)
 	}
 }
 
-func (b *Broker) NewClientChan(id uint64) chan string {
-	c := brokerChannel{
-		id:      id,
-		channel: make(chan string),
+// send renders an event for the hub's listeners and queues it for them.
+// Events which already carry their payload aren't rendered.
+func (b *MemoryBroker) send(id uint64, hub *brokerHub, event brokerEvent) {
+	b.mu.RLock()
+	render := b.renderers[event.name]
+	b.mu.RUnlock()
+	if event.data != "" {
+		render = nil
+		event.fixed = true
+	}
+	hub.sendMu.Lock()
+	defer hub.sendMu.Unlock()
+	hub.mu.Lock()
+	hub.lastID++
+	event.id = hub.lastID
+	clients := make([]*BrokerClient, 0, len(hub.clients))
+	for cl := range hub.clients {
+		clients = append(clients, cl)
+	}
+	hub.mu.Unlock()
+
+	// Render once per player, even if they have several listeners:
+	rendered := make(map[uint64]*brokerEvent)
+	for _, cl := range clients {
+		e, done := rendered[cl.playerID]
+		if !done {
+			if render == nil {
+				e = &event
+			} else if data, ok := render(id, cl.playerID); ok {
+				e = &brokerEvent{id: event.id, name: event.name, data: data}
+			}
+			rendered[cl.playerID] = e
+		}
+		if e != nil {
+			cl.push(*e)
+		}
 	}
-	b.newClients <- c
-	return c.channel
-}
 
-func (b *Broker) RemoveClientChan(id uint64, channel chan string) {
-	c := brokerChannel{
-		id:      id,
-		channel: channel,
+	hub.mu.Lock()
+	hub.history = append(hub.history, brokerHistoryEntry{
+		id:       event.id,
+		name:     event.name,
+		data:     event.data,
+		rendered: rendered,
+	})
+	if len(hub.history) > BROKER_HISTORY_SIZE {
+		hub.history = hub.history[len(hub.history)-BROKER_HISTORY_SIZE:]
 	}
-	b.closingClients <- c
+	hub.mu.Unlock()
 }
 
-func (b *Broker) Send(id uint64, message string) {
-	c := brokerMessage{
-		id:      id,
-		message: message,
+// Render computes the current payload of an event for a single listener.
+func (b *MemoryBroker) Render(id uint64, playerID uint64, name string) (brokerEvent, bool) {
+	b.mu.RLock()
+	render := b.renderers[name]
+	b.mu.RUnlock()
+	if render == nil {
+		return brokerEvent{name: name}, true
 	}
-	b.events <- c
+	data, ok := render(id, playerID)
+	return brokerEvent{name: name, data: data}, ok
 }
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitForBroker returns once the hub's worker has sent all events.
+func waitForBroker(broker *MemoryBroker, id uint64) {
+	broker.mu.RLock()
+	hub := broker.hubs[id]
+	broker.mu.RUnlock()
+	for {
+		hub.mu.Lock()
+		working := hub.working
+		hub.mu.Unlock()
+		if !working {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// benchmarkFanOut sends events to numClients listeners of one game,
+// each drained by its own goroutine like an SSE handler would do.
+func benchmarkFanOut(b *testing.B, numClients int) {
+	broker := NewMemoryBroker()
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	for x := 0; x < numClients; x++ {
+		client, _ := broker.Subscribe(1, 0, 0)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-client.Notify():
+					client.Drain()
+				case <-done:
+					return
+				}
+			}
+		}()
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		broker.Send(1, "board")
+	}
+	waitForBroker(broker, 1)
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkBrokerFanOut(b *testing.B) {
+	for _, numClients := range []int{10, 1000, 5000} {
+		b.Run(fmt.Sprintf("clients=%d", numClients), func(b *testing.B) {
+			benchmarkFanOut(b, numClients)
+		})
+	}
+}
+
+// BenchmarkBrokerStalledClients shows that listeners which never read
+// don't slow down sending, neither for their own game nor for others.
+func BenchmarkBrokerStalledClients(b *testing.B) {
+	broker := NewMemoryBroker()
+	for x := 0; x < 1000; x++ {
+		broker.Subscribe(1, 0, 0)
+	}
+	for x := 0; x < 1000; x++ {
+		broker.Subscribe(uint64(x+2), 0, 0)
+	}
+
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		broker.Send(1, "board")
+		broker.Send(uint64(n%1000+2), "players")
+	}
+	for x := 0; x < 1001; x++ {
+		waitForBroker(broker, uint64(x+1))
+	}
+}
+
+// receiveEvent waits for the next event of a listener.
+func receiveEvent(t *testing.T, client *BrokerClient) brokerEvent {
+	select {
+	case <-client.Notify():
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event received")
+	}
+	events := client.Drain()
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %v", events)
+	}
+	return events[0]
+}
+
+func TestPubSubBrokerAcrossInstances(t *testing.T) {
+	pubsub := NewLoopbackPubSub()
+	instanceA, err := NewPubSubBroker(pubsub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceB, err := NewPubSubBroker(pubsub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientA, _ := instanceA.Subscribe(1, 0, 0)
+	clientB, _ := instanceB.Subscribe(1, 0, 0)
+
+	instanceA.Send(1, "players")
+	if e := receiveEvent(t, clientA); e.name != "players" {
+		t.Errorf("instance A got %q instead of players", e.name)
+	}
+	if e := receiveEvent(t, clientB); e.name != "players" {
+		t.Errorf("instance B got %q instead of players", e.name)
+	}
+}
+
+// TestBrokerSendDoesNotRender makes sure that the sender of an event
+// doesn't wait for it being rendered.
+func TestBrokerSendDoesNotRender(t *testing.T) {
+	broker := NewMemoryBroker()
+	unblock := make(chan struct{})
+	broker.SetRenderer("board", func(id uint64, playerID uint64) (string, bool) {
+		<-unblock
+		return "{}", true
+	})
+	client, _ := broker.Subscribe(1, 0, 0)
+
+	sent := make(chan struct{})
+	go func() {
+		broker.Send(1, "board")
+		broker.Send(1, "players")
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sending is blocked by the renderer")
+	}
+	close(unblock)
+	// Events of a game keep their order:
+	waitForBroker(broker, 1)
+	events := client.Drain()
+	if len(events) != 2 || events[0].name != "board" || events[1].name != "players" {
+		t.Errorf("got events %v", events)
+	}
+}
+
+// TestPubSubBrokerSlowRenderer makes sure that rendering the events of
+// one game doesn't hold up the shared subscription.
+func TestPubSubBrokerSlowRenderer(t *testing.T) {
+	instance, err := NewPubSubBroker(NewLoopbackPubSub())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unblock := make(chan struct{})
+	defer close(unblock)
+	instance.SetRenderer("board", func(id uint64, playerID uint64) (string, bool) {
+		if id == 1 {
+			<-unblock
+		}
+		return "{}", true
+	})
+	instance.Subscribe(1, 0, 0)
+	client, _ := instance.Subscribe(2, 0, 0)
+
+	sent := make(chan struct{})
+	go func() {
+		instance.Send(1, "board")
+		instance.Send(2, "board")
+		close(sent)
+	}()
+	select {
+	case <-sent:
+	case <-time.After(2 * time.Second):
+		t.Fatal("sending is blocked by the renderer")
+	}
+	if e := receiveEvent(t, client); e.name != "board" {
+		t.Errorf("got %q instead of board", e.name)
+	}
+}
+
+// TestBrokerSendData makes sure that events with a payload are neither
+// rendered when being sent nor when being replayed.
+func TestBrokerSendData(t *testing.T) {
+	broker := NewMemoryBroker()
+	broker.SetRenderer("mulligan", func(id uint64, playerID uint64) (string, bool) {
+		return `{"rendered":true}`, true
+	})
+	client, _ := broker.Subscribe(1, 0, 0)
+	lastEventID := broker.LastEventID(1)
+
+	broker.SendData(1, "mulligan", `{"player_id":3}`)
+	if e := receiveEvent(t, client); e.data != `{"player_id":3}` {
+		t.Errorf("listener got %s", e.data)
+	}
+	resumed, ok := broker.Subscribe(1, 7, lastEventID)
+	if !ok {
+		t.Fatal("listener could not resume")
+	}
+	if e := receiveEvent(t, resumed); e.data != `{"player_id":3}` {
+		t.Errorf("resumed listener got %s", e.data)
+	}
+}
+
+func TestBrokerSendDataNotCoalesced(t *testing.T) {
+	broker := NewMemoryBroker()
+	client, _ := broker.Subscribe(1, 0, 0)
+	broker.Send(1, "board")
+	broker.SendData(1, "mulligan", `{"player_id":3}`)
+	broker.Send(1, "board")
+	broker.SendData(1, "mulligan", `{"player_id":4}`)
+	waitForBroker(broker, 1)
+
+	var got []string
+	for _, e := range client.Drain() {
+		got = append(got, e.name+" "+e.data)
+	}
+	want := []string{`mulligan {"player_id":3}`, "board ", `mulligan {"player_id":4}`}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+// fakeRedis is a minimal server implementing the Redis pubsub commands.
+type fakeRedis struct {
+	listener    net.Listener
+	mu          sync.Mutex
+	subscribers []net.Conn
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &fakeRedis{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go r.serve(conn)
+		}
+	}()
+	return r
+}
+
+func (r *fakeRedis) serve(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		v, err := redisRead(reader)
+		if err != nil {
+			return
+		}
+		args, _ := v.([]interface{})
+		if len(args) == 0 {
+			return
+		}
+		switch args[0] {
+		case "SUBSCRIBE":
+			r.mu.Lock()
+			r.subscribers = append(r.subscribers, conn)
+			r.mu.Unlock()
+			fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:1\r\n", len(args[1].(string)), args[1])
+		case "PUBLISH":
+			channel, message := args[1].(string), args[2].(string)
+			r.mu.Lock()
+			for _, sub := range r.subscribers {
+				fmt.Fprintf(sub, "*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(message), message)
+			}
+			fmt.Fprintf(conn, ":%d\r\n", len(r.subscribers))
+			r.mu.Unlock()
+		default:
+			fmt.Fprint(conn, "-ERR unknown command\r\n")
+		}
+	}
+}
+
+func TestRedisPubSubBroker(t *testing.T) {
+	server := newFakeRedis(t)
+	defer server.listener.Close()
+
+	brokerURL := "redis://" + server.listener.Addr().String() + "/test"
+	instanceA, err := newBroker(brokerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instanceB, err := newBroker(brokerURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientB, _ := instanceB.Subscribe(7, 0, 0)
+
+	instanceA.Send(7, "scoreboard")
+	if e := receiveEvent(t, clientB); e.name != "scoreboard" {
+		t.Errorf("instance B got %q instead of scoreboard", e.name)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/subtle"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+// CARD_REPORT_MIN_GUESSES is the number of guesses after which a card
+// which was never guessed correctly is reported.
+const CARD_REPORT_MIN_GUESSES = 5
+
+type jsonCardStats struct {
+	ID           uint64  `json:"id"`
+	Text         string  `json:"text"`
+	Category     string  `json:"category"`
+	TimesDealt   uint64  `json:"times_dealt"`
+	TimesGuessed uint64  `json:"times_guessed"`
+	TimesMissed  uint64  `json:"times_missed"`
+	TimesDecoy   uint64  `json:"times_decoy"`
+	Difficulty   float64 `json:"difficulty"`
+}
+
+type jsonCardReport struct {
+	// NeverGuessed lists cards whose words were never guessed correctly,
+	// which probably means that they are bad cards.
+	NeverGuessed []jsonCardStats `json:"never_guessed"`
+	// Cards lists all cards, most difficult first.
+	Cards []jsonCardStats `json:"cards"`
+}
+
+// updateCardStats recalculates the statistics of the given cards, or of
+// all cards if none are given, from the words and the guesses of scored
+// words.
+func updateCardStats(tx *gorm.DB, cardIDs ...uint64) error {
+	q := tx.Model(&Card{})
+	if len(cardIDs) > 0 {
+		q = q.Where("id IN (?)", cardIDs)
+	}
+	err := q.UpdateColumns(map[string]interface{}{
+		"times_dealt": gorm.Expr("(SELECT COUNT(words.id) FROM words WHERE words.card_id = cards.id)"),
+		// Correct and wrong guesses of words for this card:
+		"times_guessed": gorm.Expr("(SELECT COUNT(guesses.id) FROM guesses JOIN words ON words.id = guesses.word_id WHERE words.card_id = cards.id AND words.is_scored = 1 AND guesses.card_id = words.card_id)"),
+		"times_missed":  gorm.Expr("(SELECT COUNT(guesses.id) FROM guesses JOIN words ON words.id = guesses.word_id WHERE words.card_id = cards.id AND words.is_scored = 1 AND guesses.card_id <> words.card_id)"),
+		// Guesses of this card for other words:
+		"times_decoy": gorm.Expr("(SELECT COUNT(guesses.id) FROM guesses JOIN words ON words.id = guesses.word_id WHERE guesses.card_id = cards.id AND words.is_scored = 1 AND words.card_id <> cards.id)"),
+	}).Error
+	if err != nil {
+		return err
+	}
+	// The share of wrong guesses of the card's words, and the share of
+	// picks of the card which were wrong, as it is easily confused with
+	// other words. Both start at 0.5 for unknown cards:
+	return q.UpdateColumn("difficulty", gorm.Expr("((times_missed + 1.0) / (times_guessed + times_missed + 2.0) + (times_decoy + 1.0) / (times_guessed + times_decoy + 2.0)) / 2")).Error
+}
+
+// backfillCardStats calculates the statistics of cards which have been
+// dealt before they were learned, e.g. in databases of older versions.
+// All others are kept up to date by updateRoundCardStats.
+func backfillCardStats() error {
+	var cardIDs []uint64
+	q := db.Model(&Card{}).Where("times_dealt = 0 AND id IN (SELECT card_id FROM words)")
+	err := q.Pluck("id", &cardIDs).Error
+	if err != nil {
+		return err
+	}
+	// Stay well below SQLite's limit of variables per statement:
+	for len(cardIDs) > 0 {
+		n := len(cardIDs)
+		if n > 500 {
+			n = 500
+		}
+		err = updateCardStats(db, cardIDs[:n]...)
+		if err != nil {
+			return err
+		}
+		cardIDs = cardIDs[n:]
+	}
+	return nil
+}
+
+// updateRoundCardStats updates the statistics of all cards of a game's
+// round once it is finished.
+func updateRoundCardStats(tx *gorm.DB, game *Game) error {
+	var cardIDs []uint64
+	err := tx.Model(&Word{}).Where("game_id = ? AND round = ?", game.ID, game.Round).Pluck("card_id", &cardIDs).Error
+	if err != nil || len(cardIDs) == 0 {
+		return err
+	}
+	return updateCardStats(tx, cardIDs...)
+}
+
+// requireAdmin checks the admin token. The admin API is disabled unless a
+// token is configured.
+func requireAdmin(c *gin.Context) bool {
+	if adminToken == "" {
+		c.JSON(404, nil)
+		return false
+	}
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+		c.JSON(401, gin.H{"error": "invalid admin token"})
+		return false
+	}
+	return true
+}
+
+func getCardReport(c *gin.Context) {
+	if !requireAdmin(c) {
+		return
+	}
+	minGuesses := uint64(CARD_REPORT_MIN_GUESSES)
+	if v := c.Query("min_guesses"); v != "" {
+		var err error
+		minGuesses, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "invalid min_guesses"})
+			return
+		}
+	}
+
+	var cards []Card
+	err := db.Order("difficulty DESC, id").Find(&cards).Error
+	if err != nil {
+		log.Printf("failed to query cards: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	report := jsonCardReport{
+		NeverGuessed: make([]jsonCardStats, 0),
+		Cards:        make([]jsonCardStats, 0, len(cards)),
+	}
+	for _, card := range cards {
+		stats := jsonCardStats{
+			ID:           card.ID,
+			Text:         card.Text,
+			Category:     card.Category,
+			TimesDealt:   card.TimesDealt,
+			TimesGuessed: card.TimesGuessed,
+			TimesMissed:  card.TimesMissed,
+			TimesDecoy:   card.TimesDecoy,
+			Difficulty:   card.Difficulty,
+		}
+		report.Cards = append(report.Cards, stats)
+		if card.TimesGuessed == 0 && card.TimesMissed >= minGuesses {
+			report.NeverGuessed = append(report.NeverGuessed, stats)
+		}
+	}
+	c.JSON(200, report)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+// addTestGuesses adds a scored word for the card, which the players
+// guess as the given cards.
+func addTestGuesses(t *testing.T, gameID uint64, cardID uint64, guesses []uint64) {
+	word := Word{GameID: gameID, Round: 1, CardID: cardID, IsScored: true}
+	err := db.Create(&word).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, guess := range guesses {
+		err = db.Create(&Guess{GameID: gameID, Round: 1, PlayerID: uint64(x + 1), WordID: word.ID, CardID: guess}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCardDifficulty(t *testing.T) {
+	setupTestDB(t)
+	// Cards 1 and 2 are guessed equally well, but card 1 is mistaken
+	// for card 3 all the time:
+	addTestGuesses(t, 1, 1, []uint64{1, 1, 3, 3})
+	addTestGuesses(t, 1, 2, []uint64{2, 2, 3, 3})
+	addTestGuesses(t, 1, 3, []uint64{1, 1, 1, 1})
+	err := updateCardStats(db, 1, 2, 3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var cards []Card
+	err = db.Where("id IN (?)", []uint64{1, 2, 3, 4}).Order("id").Find(&cards).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cards[0].TimesGuessed != cards[1].TimesGuessed || cards[0].TimesMissed != cards[1].TimesMissed {
+		t.Fatalf("cards 1 and 2 are guessed differently: %+v, %+v", cards[0], cards[1])
+	}
+	if cards[0].TimesDecoy != 4 || cards[1].TimesDecoy != 0 {
+		t.Errorf("got times_decoy %d and %d, want 4 and 0", cards[0].TimesDecoy, cards[1].TimesDecoy)
+	}
+	if cards[0].Difficulty <= cards[1].Difficulty {
+		t.Errorf("card 1 with decoys got difficulty %f, card 2 without %f", cards[0].Difficulty, cards[1].Difficulty)
+	}
+	if cards[3].Difficulty != 0.5 {
+		t.Errorf("unknown card got difficulty %f", cards[3].Difficulty)
+	}
+}
+
+func TestBackfillCardStats(t *testing.T) {
+	setupTestDB(t)
+	addTestGuesses(t, 1, 1, []uint64{1, 2})
+	err := backfillCardStats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var card Card
+	err = db.First(&card, 1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	if card.TimesDealt != 1 || card.TimesGuessed != 1 || card.TimesMissed != 1 {
+		t.Errorf("got %+v", card)
+	}
+}
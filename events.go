@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// SSE_RETRY is the reconnection delay recommended to clients.
+	SSE_RETRY = 2 * time.Second
+	// SSE_HEARTBEAT_INTERVAL is the maximum time without any data on
+	// an event stream.
+	SSE_HEARTBEAT_INTERVAL = 15 * time.Second
+)
+
+// setupEventRenderers defines the payloads which are sent along with
+// broker events, so that clients don't have to refetch state.
+func setupEventRenderers() {
+	broker.SetRenderer("board", renderBoardEvent)
+	broker.SetRenderer("players", renderPlayersEvent)
+	broker.SetRenderer("scoreboard", renderScoreboardEvent)
+	broker.SetRenderer("phase_changed", renderPhaseChangedEvent)
+	broker.SetRenderer("lobby", renderLobbyEvent)
+	broker.SetRenderer("settings", renderSettingsEvent)
+	broker.SetRenderer("public_board", renderPublicBoardEvent)
+}
+
+// publishBoard announces a board change to all listeners of a game.
+// Every change increases the game's version so that clients can tell
+// outdated boards apart.
+func publishBoard(gameID uint64) {
+	err := db.Model(&Game{}).Where("id = ?", gameID).UpdateColumn("version", gorm.Expr("version + 1")).Error
+	if err != nil {
+		log.Printf("failed to increase game version: %s", err)
+	}
+	broker.Send(gameID, "board")
+	broker.Send(gameID, "public_board")
+}
+
+// publishMulligan announces that the player has redrawn their letters.
+// Its payload is fixed right away, as other redraws may have happened by
+// the time the event gets delivered.
+func publishMulligan(player Player) {
+	data, ok := renderEventJson(gin.H{"player_id": player.ID, "name": player.Name, "round": player.Game.Round})
+	if ok {
+		broker.SendData(player.Game.ID, "mulligan", data)
+	}
+}
+
+func renderBoardEvent(gameID uint64, playerID uint64) (string, bool) {
+	if playerID == 0 {
+		// Boards are player-specific, game-wide listeners only
+		// get notified in compatibility mode:
+		return "", bareEvents
+	}
+	var player Player
+	err := db.First(&player, playerID).Error
+	if err != nil {
+		log.Printf("failed to find player for board event: %s", err)
+		return "", false
+	}
+	err = db.First(&player.Game, player.GameID).Error
+	if err != nil {
+		log.Printf("failed to find game for board event: %s", err)
+		return "", false
+	}
+	board, err := getBoardJson(player)
+	if err != nil {
+		log.Printf("getBoardJson failed for board event: %s", err)
+		return "", false
+	}
+	return renderEventJson(board)
+}
+
+func renderPlayersEvent(gameID uint64, playerID uint64) (string, bool) {
+	names, err := getPlayerNames(Game{ID: gameID})
+	if err != nil {
+		log.Printf("failed to query players for players event: %s", err)
+		return "", false
+	}
+	return renderEventJson(gin.H{"players": names})
+}
+
+func renderScoreboardEvent(gameID uint64, playerID uint64) (string, bool) {
+	order, scores, err := getScoreByPlayers(gameID)
+	if err != nil {
+		log.Printf("getScoreByPlayers failed for scoreboard event: %s", err)
+		return "", false
+	}
+	scoreboard := jsonScoreboard{Scoreboard: make([]jsonScoreboardRow, 0, len(order))}
+	for _, id := range order {
+		score := scores[id]
+		scoreboard.Scoreboard = append(scoreboard.Scoreboard, jsonScoreboardRow{
+			Name:                score.Name,
+			ScoreTotal:          score.ScoreTotal,
+			ScoreOwnWords:       score.ScoreOwnWords,
+			ScoreCorrectGuesses: score.ScoreCorrectGuesses,
+		})
+	}
+	return renderEventJson(scoreboard)
+}
+
+func renderPhaseChangedEvent(gameID uint64, playerID uint64) (string, bool) {
+	var game Game
+	err := db.First(&game, gameID).Error
+	if err != nil {
+		log.Printf("failed to find game for phase_changed event: %s", err)
+		return "", false
+	}
+	return renderEventJson(gin.H{"phase": game.Phase, "round": game.Round})
+}
+
+func renderEventJson(v interface{}) (string, bool) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("failed to encode event payload: %s", err)
+		return "", false
+	}
+	return string(data), true
+}
+
+func streamGameEvents(c *gin.Context) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	streamEvents(c, game.ID, 0, "players", "scoreboard", "public_board")
+}
+
+// streamPlayerEvents delivers events with the player's own view of the
+// board as payload.
+func streamPlayerEvents(c *gin.Context) {
+	player, err := getVerifiedPlayer(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("getVerifiedPlayer failed: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	streamEvents(c, player.Game.ID, player.ID, "board")
+}
+
+// streamEvents sends broker events as Server-Sent Events. Reconnecting
+// clients get the events they missed based on the Last-Event-ID header,
+// all others get the current state of the events listed in initial.
+func streamEvents(c *gin.Context, gameID uint64, playerID uint64, initial ...string) {
+	lastEventHeader := c.GetHeader("Last-Event-ID")
+	if lastEventHeader == "" {
+		// Clients which create a new connection can only pass it this way:
+		lastEventHeader = c.Query("last_event_id")
+	}
+	lastEventID, _ := strconv.ParseUint(lastEventHeader, 10, 64)
+	client, resumed := broker.Subscribe(gameID, playerID, lastEventID)
+	defer broker.Unsubscribe(gameID, client)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	// Ask reverse proxies not to buffer the stream:
+	c.Header("X-Accel-Buffering", "no")
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", SSE_RETRY/time.Millisecond)
+	if !resumed {
+		id := broker.LastEventID(gameID)
+		for _, name := range initial {
+			if e, ok := broker.Render(gameID, playerID, name); ok {
+				e.id = id
+				writeEvent(c, e)
+			}
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(SSE_HEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-client.Notify():
+		case <-heartbeat.C:
+			// Keep idle connections from being cut by proxies:
+			fmt.Fprint(w, ": keepalive\n\n")
+			return true
+		case <-c.Request.Context().Done():
+			return false
+		}
+		for _, e := range client.Drain() {
+			writeEvent(c, e)
+		}
+		return true
+	})
+}
+
+func writeEvent(c *gin.Context, e brokerEvent) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(e.id, 10),
+		Event: e.name,
+		Data:  e.data,
+	})
+}
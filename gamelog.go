@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// GAME_EVENT_* are the types of GameEvent entries.
+	GAME_EVENT_GAME_CREATED    = "game-created"
+	GAME_EVENT_PLAYER_JOINED   = "player-joined"
+	GAME_EVENT_PROFILE_LINKED  = "profile-linked"
+	GAME_EVENT_PLAYER_READY    = "player-ready"
+	GAME_EVENT_ROUND_STARTED   = "round-started"
+	GAME_EVENT_WORD_SUBMITTED  = "word-submitted"
+	GAME_EVENT_LETTERS_REDRAWN = "letters-redrawn"
+	GAME_EVENT_GUESSES_CHANGED = "guesses-changed"
+	GAME_EVENT_WORD_SCORED     = "word-scored"
+	// The game's settings are kept when rebuilding, so changes are only
+	// logged for reference:
+	GAME_EVENT_SETTINGS_CHANGED = "settings-changed"
+)
+
+// GameEvent is an entry of a game's append-only log. The log is written
+// along with the changes to players, words and guesses, which can be
+// rebuilt from it.
+type GameEvent struct {
+	ID        uint64
+	GameID    uint64 `gorm:"index; not null"`
+	Round     int64
+	PlayerID  *uint64 // NULL for events without player.
+	Type      string  `gorm:"not null"`
+	Data      string  // JSON, see gameEvent* below.
+	CreatedAt time.Time
+}
+
+// gameEventPlayerJoined leaves out the player's token, as the log may be
+// handed out, e.g. for settling disputes. Rebuilt players get new tokens.
+type gameEventPlayerJoined struct {
+	Name      string  `json:"name"`
+	ProfileID *uint64 `json:"profile_id,omitempty"`
+	Queued    bool    `json:"queued,omitempty"`
+}
+
+type gameEventProfileLinked struct {
+	ProfileID uint64 `json:"profile_id"`
+}
+
+type gameEventRoundStarted struct {
+	Cards []gameEventCard `json:"cards"`
+}
+
+type gameEventCard struct {
+	CardID   uint64  `json:"card_id"`
+	PlayerID *uint64 `json:"player_id"` // nil for additional cards.
+	Letters  string  `json:"letters"`
+}
+
+type gameEventWordSubmitted struct {
+	Word   string `json:"word"`
+	Jokers []int  `json:"jokers,omitempty"`
+}
+
+type gameEventLettersRedrawn struct {
+	Letters string `json:"letters"`
+}
+
+type gameEventGuessesChanged struct {
+	Guesses jsonGuesses `json:"guesses"`
+}
+
+type gameEventWordScored struct {
+	CardID uint64 `json:"card_id"`
+}
+
+// appendGameEvent logs an event. It should be called within the
+// transaction which performs the logged change.
+func appendGameEvent(tx *gorm.DB, game Game, playerID *uint64, eventType string, data interface{}) error {
+	event := GameEvent{
+		GameID:   game.ID,
+		Round:    game.Round,
+		PlayerID: playerID,
+		Type:     eventType,
+	}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		event.Data = string(encoded)
+	}
+	err := tx.Create(&event).Error
+	if err != nil {
+		return fmt.Errorf("failed to log %s event: %s", eventType, err)
+	}
+	return nil
+}
+
+func getGameEvents(tx *gorm.DB, gameID uint64) ([]GameEvent, error) {
+	var events []GameEvent
+	err := tx.Where("game_id = ?", gameID).Order("id").Find(&events).Error
+	return events, err
+}
+
+// dumpGameLog writes a game's log as one JSON object per line.
+func dumpGameLog(w io.Writer, gameToken string) error {
+	var game Game
+	err := db.Where("token = ?", gameToken).First(&game).Error
+	if err != nil {
+		return fmt.Errorf("failed to find game: %s", err)
+	}
+	events, err := getGameEvents(db, game.ID)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range events {
+		err = enc.Encode(struct {
+			Time     time.Time       `json:"time"`
+			Round    int64           `json:"round"`
+			PlayerID *uint64         `json:"player_id,omitempty"`
+			Type     string          `json:"type"`
+			Data     json.RawMessage `json:"data,omitempty"`
+		}{e.CreatedAt, e.Round, e.PlayerID, e.Type, json.RawMessage(e.Data)})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateGameEventTokens removes the player tokens from the logs of
+// games which were started while they were still logged.
+func migrateGameEventTokens() error {
+	var events []GameEvent
+	err := db.Where("type = ? AND data LIKE ?", GAME_EVENT_PLAYER_JOINED, `%"token":%`).Find(&events).Error
+	if err != nil {
+		return err
+	}
+	for _, e := range events {
+		var data gameEventPlayerJoined
+		err = json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		err = db.Model(&e).UpdateColumn("data", string(encoded)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rebuildGame replaces a game's players, words and guesses with the state
+// recorded in its log. As the log has no tokens, the players are returned
+// with new ones.
+func rebuildGame(gameToken string) ([]Player, error) {
+	var game Game
+	err := db.Where("token = ?", gameToken).First(&game).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to find game: %s", err)
+	}
+	var players []Player
+	err = db.Transaction(func(tx *gorm.DB) error {
+		events, err := getGameEvents(tx, game.ID)
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 || events[0].Type != GAME_EVENT_GAME_CREATED {
+			// The game was started before the log existed.
+			return errors.New("game log is incomplete")
+		}
+
+		for _, model := range []interface{}{&Guess{}, &Word{}, &Player{}} {
+			err = tx.Where("game_id = ?", game.ID).Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+		game.Round = 1
+		for _, e := range events {
+			err = replayGameEvent(tx, &game, e)
+			if err != nil {
+				return fmt.Errorf("failed to replay event %d (%s): %s", e.ID, e.Type, err)
+			}
+		}
+
+		game.Phase, err = game.DerivePhase(tx)
+		if err != nil {
+			return err
+		}
+		err = tx.Model(&game).UpdateColumns(map[string]interface{}{
+			"round":   game.Round,
+			"phase":   game.Phase,
+			"version": gorm.Expr("version + 1"),
+		}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Where("game_id = ?", game.ID).Order("id").Find(&players).Error
+	})
+	return players, err
+}
+
+func replayGameEvent(tx *gorm.DB, game *Game, e GameEvent) error {
+	switch e.Type {
+	case GAME_EVENT_GAME_CREATED, GAME_EVENT_SETTINGS_CHANGED:
+		return nil
+
+	case GAME_EVENT_PLAYER_JOINED:
+		var data gameEventPlayerJoined
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&Player{
+			ID:        *e.PlayerID,
+			GameID:    game.ID,
+			Token:     generateToken(),
+			Name:      data.Name,
+			ProfileID: data.ProfileID,
+			Queued:    data.Queued,
+		}).Error
+
+	case GAME_EVENT_PROFILE_LINKED:
+		var data gameEventProfileLinked
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&Player{}).Where("id = ?", *e.PlayerID).UpdateColumn("profile_id", data.ProfileID).Error
+
+	case GAME_EVENT_PLAYER_READY:
+		return tx.Model(&Player{}).Where("id = ?", *e.PlayerID).UpdateColumn("round", e.Round).Error
+
+	case GAME_EVENT_ROUND_STARTED:
+		var data gameEventRoundStarted
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		game.Round = e.Round
+		err = seatQueuedPlayers(tx, game)
+		if err != nil {
+			return err
+		}
+		for _, card := range data.Cards {
+			err = tx.Create(&Word{
+				GameID:   game.ID,
+				Round:    e.Round,
+				CardID:   card.CardID,
+				PlayerID: card.PlayerID,
+				Letters:  card.Letters,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case GAME_EVENT_WORD_SUBMITTED:
+		var data gameEventWordSubmitted
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		jokerPenalty := 0
+		if game.JokerPenalty {
+			jokerPenalty = len(data.Jokers)
+		}
+		q := tx.Model(&Word{})
+		q = q.Where("game_id = ? AND round = ? AND player_id = ?", game.ID, e.Round, *e.PlayerID)
+		return q.UpdateColumns(map[string]interface{}{
+			"word":          data.Word,
+			"jokers":        formatJokers(data.Jokers),
+			"joker_penalty": jokerPenalty,
+		}).Error
+
+	case GAME_EVENT_LETTERS_REDRAWN:
+		var data gameEventLettersRedrawn
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		q := tx.Model(&Word{})
+		q = q.Where("game_id = ? AND round = ? AND player_id = ?", game.ID, e.Round, *e.PlayerID)
+		return q.UpdateColumns(map[string]interface{}{
+			"letters": data.Letters,
+			"redraws": gorm.Expr("redraws + 1"),
+		}).Error
+
+	case GAME_EVENT_GUESSES_CHANGED:
+		var data gameEventGuessesChanged
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		err = tx.Where(Guess{
+			GameID:   game.ID,
+			Round:    e.Round,
+			PlayerID: *e.PlayerID,
+		}).Delete(Guess{}).Error
+		if err != nil {
+			return err
+		}
+		for playerID, cardID := range data.Guesses {
+			var word Word
+			err = tx.Where(Word{
+				GameID:   game.ID,
+				Round:    e.Round,
+				PlayerID: &playerID,
+			}).First(&word).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Create(&Guess{
+				GameID:   game.ID,
+				Round:    e.Round,
+				PlayerID: *e.PlayerID,
+				WordID:   word.ID,
+				CardID:   cardID,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case GAME_EVENT_WORD_SCORED:
+		var data gameEventWordScored
+		err := json.Unmarshal([]byte(e.Data), &data)
+		if err != nil {
+			return err
+		}
+		q := tx.Model(&Word{})
+		q = q.Where("game_id = ? AND card_id = ?", game.ID, data.CardID)
+		err = q.UpdateColumn("is_scored", true).Error
+		if err != nil {
+			return err
+		}
+		// The round is over after the last word:
+		done, err := allWordsScored(tx, game)
+		if err == nil && done {
+			game.Round++
+		}
+		return err
+	}
+	return fmt.Errorf("unknown event type %q", e.Type)
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// setupTestDB replaces the database and the broker for the test. The
+// database holds a few cards but no games.
+func setupTestDB(t *testing.T) {
+	tdb, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection would get its own in-memory database:
+	tdb.DB().SetMaxOpenConns(1)
+	tdb.AutoMigrate(&Game{}, &Player{}, &Card{}, &Word{}, &Guess{}, &GameEvent{}, &Profile{}, &Room{})
+	for x := 0; x < 30; x++ {
+		err = tdb.Create(&Card{Text: fmt.Sprintf("card %d", x)}).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	set, err := readTileSet("tiles/de.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tileSets[GAME_LANGUAGE_DEFAULT] = set
+	gin.SetMode(gin.TestMode)
+
+	oldDB, oldBroker := db, broker
+	db, broker = tdb, NewMemoryBroker()
+	t.Cleanup(func() {
+		db, broker = oldDB, oldBroker
+		tdb.Close()
+	})
+}
+
+// createTestGame creates a game with three players like startNewGame and
+// joinGame do.
+func createTestGame(t *testing.T) (Game, []Player) {
+	settings := defaultGameSettings()
+	// Words are made of single tiles:
+	settings.MinWordLength = 1
+	settings.RequireVowel = false
+	settings.BanCardWords = false
+	game := Game{
+		Token:        generateToken(),
+		Round:        1,
+		Phase:        GAME_PHASE_WAIT_FOR_READY,
+		GameSettings: settings,
+	}
+	err := db.Create(&game).Error
+	if err == nil {
+		err = appendGameEvent(db, game, nil, GAME_EVENT_GAME_CREATED, nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/", nil)
+	var players []Player
+	for x := 0; x < 3; x++ {
+		player, err := addPlayer(c, game, fmt.Sprintf("Player %d", x+1), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		players = append(players, player)
+	}
+	return game, players
+}
+
+func reloadTestGame(t *testing.T, game Game) Game {
+	err := db.First(&game, game.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return game
+}
+
+// submitTestWord lays the player's first letter tile.
+func submitTestWord(t *testing.T, game Game, player Player) {
+	var word Word
+	err := db.Where("game_id = ? AND round = ? AND player_id = ?", game.ID, game.Round, player.ID).First(&word).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tile := range parseTiles(word.Letters) {
+		if tile != "\u00a0" && tile != string(JOKER) {
+			err = saveWord(player, tile, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+	}
+	t.Fatalf("no letters in %q", word.Letters)
+}
+
+// guessTestWords assigns the right cards to the other players' words.
+func guessTestWords(t *testing.T, game Game, player Player) {
+	var words []Word
+	err := db.Where("game_id = ? AND round = ? AND player_id IS NOT NULL", game.ID, game.Round).Find(&words).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	guesses := make(jsonGuesses)
+	for _, word := range words {
+		if *word.PlayerID != player.ID {
+			guesses[*word.PlayerID] = word.CardID
+		}
+	}
+	err = saveGuesses(player, guesses)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// playTestRound plays the game's current round from the start to the
+// end. Every player guesses all cards right.
+func playTestRound(t *testing.T, game Game, players []Player) Game {
+	return playCheckedTestRound(t, game, players, func(Game) {})
+}
+
+// playCheckedTestRound is playTestRound, calling check with the reloaded
+// game after every action.
+func playCheckedTestRound(t *testing.T, game Game, players []Player, check func(Game)) Game {
+	for _, player := range players {
+		err := setPlayerReady(player)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(reloadTestGame(t, game))
+	}
+	game = reloadTestGame(t, game)
+	err := redrawLetters(players[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(reloadTestGame(t, game))
+	for _, player := range players {
+		submitTestWord(t, game, player)
+		check(reloadTestGame(t, game))
+	}
+
+	for _, player := range players {
+		guessTestWords(t, game, player)
+		check(reloadTestGame(t, game))
+	}
+
+	for game = reloadTestGame(t, game); game.Phase == GAME_PHASE_SCORE; game = reloadTestGame(t, game) {
+		word, err := getCurrentlyScoredWord(game)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = scoreCurrentWord(Player{ID: *word.PlayerID, GameID: game.ID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(reloadTestGame(t, game))
+	}
+	return game
+}
+
+// snapshotGame describes everything that rebuildGame restores, apart
+// from ids which may change and tokens which have to.
+func snapshotGame(t *testing.T, game Game) []string {
+	game = reloadTestGame(t, game)
+	snapshot := []string{fmt.Sprintf("game round=%d phase=%s", game.Round, game.Phase)}
+
+	var players []Player
+	err := db.Where("game_id = ?", game.ID).Order("id").Find(&players).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range players {
+		snapshot = append(snapshot, fmt.Sprintf("player %d %s round=%d queued=%v first_round=%d", p.ID, p.Name, p.Round, p.Queued, p.FirstRound))
+	}
+
+	var words []Word
+	err = db.Where("game_id = ?", game.ID).Order("round, card_id").Find(&words).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	cardOfWord := make(map[uint64]uint64)
+	for _, w := range words {
+		cardOfWord[w.ID] = w.CardID
+		var playerID uint64
+		if w.PlayerID != nil {
+			playerID = *w.PlayerID
+		}
+		snapshot = append(snapshot, fmt.Sprintf("word round=%d card=%d player=%d word=%q letters=%q jokers=%q penalty=%d redraws=%d scored=%v",
+			w.Round, w.CardID, playerID, w.Word, w.Letters, w.Jokers, w.JokerPenalty, w.Redraws, w.IsScored))
+	}
+
+	var guesses []Guess
+	err = db.Where("game_id = ?", game.ID).Order("round, player_id, card_id").Find(&guesses).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range guesses {
+		snapshot = append(snapshot, fmt.Sprintf("guess round=%d player=%d word_card=%d card=%d", g.Round, g.PlayerID, cardOfWord[g.WordID], g.CardID))
+	}
+	return snapshot
+}
+
+func TestRebuildGame(t *testing.T) {
+	setupTestDB(t)
+	game, players := createTestGame(t)
+	game = playTestRound(t, game, players)
+	if game.Round != 2 || game.Phase != GAME_PHASE_WAIT_FOR_READY {
+		t.Fatalf("game is in round %d, phase %s after the first round", game.Round, game.Phase)
+	}
+	// Stop in the middle of the second round:
+	for _, player := range players {
+		err := setPlayerReady(player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	game = reloadTestGame(t, game)
+	submitTestWord(t, game, players[1])
+
+	before := snapshotGame(t, game)
+	rebuilt, err := rebuildGame(game.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after := snapshotGame(t, game)
+	if !reflect.DeepEqual(before, after) {
+		t.Errorf("rebuilt game differs:\n%s\nwant:\n%s", strings.Join(after, "\n"), strings.Join(before, "\n"))
+	}
+
+	if len(rebuilt) != len(players) {
+		t.Fatalf("rebuilt %d players, want %d", len(rebuilt), len(players))
+	}
+	events, err := getGameEvents(db, game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x, player := range rebuilt {
+		if player.Token == "" || player.Token == players[x].Token {
+			t.Errorf("player %d kept the token %q", player.ID, player.Token)
+		}
+		for _, e := range events {
+			if strings.Contains(e.Data, players[x].Token) {
+				t.Errorf("event %d (%s) contains the token of player %d", e.ID, e.Type, player.ID)
+			}
+		}
+	}
+}
+
+func TestMigrateGameEventTokens(t *testing.T) {
+	setupTestDB(t)
+	game, _ := createTestGame(t)
+	err := db.Model(&GameEvent{}).Where("type = ?", GAME_EVENT_PLAYER_JOINED).UpdateColumn("data", `{"name":"Player 1","token":"secret"}`).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrateGameEventTokens()
+	if err != nil {
+		t.Fatal(err)
+	}
+	events, err := getGameEvents(db, game.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range events {
+		if e.Type == GAME_EVENT_PLAYER_JOINED && e.Data != `{"name":"Player 1"}` {
+			t.Errorf("unexpected event data %s", e.Data)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type jsonRounds struct {
+	Players []jsonRoundPlayer `json:"players"`
+	Rounds  []jsonRound       `json:"rounds"`
+	// ScoreSeries holds each player's total score after every round.
+	ScoreSeries map[uint64][]uint64 `json:"score_series"`
+}
+
+type jsonRoundPlayer struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+type jsonRound struct {
+	Round int64           `json:"round"`
+	Cards []jsonRoundCard `json:"cards"`
+	// Guesses holds the guesses of every player by the guessing player.
+	Guesses map[uint64]jsonGuesses     `json:"guesses"`
+	Points  map[uint64]jsonRoundPoints `json:"points"`
+}
+
+type jsonRoundCard struct {
+	ID       uint64   `json:"id"`
+	Text     string   `json:"text"`
+	PlayerID *uint64  `json:"player_id"` // null for additional cards.
+	Word     string   `json:"word"`
+	Letters  string   `json:"letters"`
+	Tiles    []string `json:"tiles"`
+	Jokers   []int    `json:"jokers"`
+	Redraws  int      `json:"redraws"`
+}
+
+type jsonRoundPoints struct {
+	ScoreTotal          uint64 `json:"score_total"`
+	ScoreOwnWords       uint64 `json:"score_own_words"`
+	ScoreCorrectGuesses uint64 `json:"score_correct_guesses"`
+}
+
+func getRounds(c *gin.Context) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+
+	rounds, err := getRoundHistory(game)
+	if err != nil {
+		log.Printf("getRoundHistory failed: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, rounds)
+}
+
+func getRound(c *gin.Context) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+
+	n, err := strconv.ParseInt(c.Param("round"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid round"})
+		return
+	}
+	rounds, err := getRoundHistory(game)
+	if err != nil {
+		log.Printf("getRoundHistory failed: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	for _, round := range rounds.Rounds {
+		if round.Round == n {
+			c.JSON(200, round)
+			return
+		}
+	}
+	c.JSON(404, gin.H{"error": "round not finished"})
+}
+
+// getRoundHistory reveals everything about the game's finished rounds.
+func getRoundHistory(game Game) (jsonRounds, error) {
+	history := jsonRounds{
+		Players:     make([]jsonRoundPlayer, 0),
+		Rounds:      make([]jsonRound, 0),
+		ScoreSeries: make(map[uint64][]uint64),
+	}
+
+	var players []Player
+	err := db.Where("game_id = ?", game.ID).Order("id").Find(&players).Error
+	if err != nil {
+		return history, err
+	}
+	for _, player := range players {
+		history.Players = append(history.Players, jsonRoundPlayer{
+			ID:   player.ID,
+			Name: player.Name,
+		})
+		history.ScoreSeries[player.ID] = make([]uint64, 0)
+	}
+
+	// The round number is increased once all words have been scored:
+	var words []Word
+	q := db.Preload("Card")
+	q = q.Where("game_id = ? AND round < ?", game.ID, game.Round)
+	q = q.Order("round, id")
+	err = q.Find(&words).Error
+	if err != nil {
+		return history, err
+	}
+	var guesses []Guess
+	err = db.Where("game_id = ? AND round < ?", game.ID, game.Round).Order("id").Find(&guesses).Error
+	if err != nil {
+		return history, err
+	}
+
+	wordsByID := make(map[uint64]Word, len(words))
+	roundIndex := make(map[int64]int)
+	for _, word := range words {
+		wordsByID[word.ID] = word
+		i, ok := roundIndex[word.Round]
+		if !ok {
+			i = len(history.Rounds)
+			roundIndex[word.Round] = i
+			history.Rounds = append(history.Rounds, jsonRound{
+				Round:   word.Round,
+				Cards:   make([]jsonRoundCard, 0),
+				Guesses: make(map[uint64]jsonGuesses),
+				Points:  make(map[uint64]jsonRoundPoints),
+			})
+			for _, player := range players {
+				history.Rounds[i].Points[player.ID] = jsonRoundPoints{}
+			}
+		}
+		history.Rounds[i].Cards = append(history.Rounds[i].Cards, jsonRoundCard{
+			ID:       word.CardID,
+			Text:     word.Card.Text,
+			PlayerID: word.PlayerID,
+			Word:     word.Word,
+			Letters:  strings.Join(parseTiles(word.Letters), ""),
+			Tiles:    parseTiles(word.Letters),
+			Jokers:   parseJokers(word.Jokers),
+			Redraws:  word.Redraws,
+		})
+	}
+
+	timesGuessed := make(map[uint64]int)
+	for _, guess := range guesses {
+		round := &history.Rounds[roundIndex[guess.Round]]
+		word := wordsByID[guess.WordID]
+		if round.Guesses[guess.PlayerID] == nil {
+			round.Guesses[guess.PlayerID] = make(jsonGuesses)
+		}
+		round.Guesses[guess.PlayerID][*word.PlayerID] = guess.CardID
+
+		if guess.CardID != word.CardID {
+			continue
+		}
+		// Correct guesses score for the guesser and the word's author:
+		points := round.Points[guess.PlayerID]
+		points.ScoreCorrectGuesses++
+		points.ScoreTotal++
+		round.Points[guess.PlayerID] = points
+		points = round.Points[*word.PlayerID]
+		points.ScoreOwnWords++
+		points.ScoreTotal++
+		round.Points[*word.PlayerID] = points
+		timesGuessed[word.ID]++
+	}
+
+	// Jokers may cost points, but never more than the word has scored:
+	for _, word := range words {
+		penalty := word.JokerPenalty
+		if penalty > timesGuessed[word.ID] {
+			penalty = timesGuessed[word.ID]
+		}
+		if penalty == 0 {
+			continue
+		}
+		round := &history.Rounds[roundIndex[word.Round]]
+		points := round.Points[*word.PlayerID]
+		points.ScoreOwnWords -= uint64(penalty)
+		points.ScoreTotal -= uint64(penalty)
+		round.Points[*word.PlayerID] = points
+	}
+
+	for _, player := range players {
+		var total uint64
+		for _, round := range history.Rounds {
+			total += round.Points[player.ID].ScoreTotal
+			history.ScoreSeries[player.ID] = append(history.ScoreSeries[player.ID], total)
+		}
+	}
+	return history, nil
+}
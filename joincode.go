@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	// JOIN_CODE_LETTERS and JOIN_CODE_DIGITS leave out characters which
+	// look or sound alike, such as O and 0, B, D, P and T, M and N or F
+	// and S.
+	JOIN_CODE_LETTERS = "AHJKLMRSWXY"
+	JOIN_CODE_DIGITS  = "23456789"
+	// JOIN_CODE_ATTEMPTS limits the retries when a generated code is
+	// already taken.
+	JOIN_CODE_ATTEMPTS = 10
+	// JOIN_CODE_MAX_AGE is how long a code leads to a game which is
+	// still waiting for players. After that, or once the game has
+	// started, the code is free for new games.
+	JOIN_CODE_MAX_AGE = 24 * time.Hour
+	// QR_CODE_SIZE is the width and height of QR code PNGs in pixels.
+	QR_CODE_SIZE = 256
+)
+
+// JOIN_CODE_RE matches normalized join codes like WHK-4821.
+var JOIN_CODE_RE = regexp.MustCompile("^[" + JOIN_CODE_LETTERS + "]{3}-[" + JOIN_CODE_DIGITS + "]{4}$")
+
+func generateJoinCode() string {
+	code := make([]byte, 0, 8)
+	for x := 0; x < 7; x++ {
+		chars := JOIN_CODE_LETTERS
+		if x >= 3 {
+			chars = JOIN_CODE_DIGITS
+		}
+		if x == 3 {
+			code = append(code, '-')
+		}
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(chars))))
+		if err != nil {
+			panic(fmt.Sprintf("rand.Int failed: %s", err))
+		}
+		code = append(code, chars[n.Int64()])
+	}
+	return string(code)
+}
+
+// normalizeJoinCode accepts codes as they are typed, e.g. "whk 4821".
+func normalizeJoinCode(s string) (string, bool) {
+	s = strings.ToUpper(s)
+	s = strings.NewReplacer("-", "", " ", "").Replace(s)
+	if len(s) != 7 {
+		return "", false
+	}
+	code := s[:3] + "-" + s[3:]
+	return code, JOIN_CODE_RE.MatchString(code)
+}
+
+// whereJoinCodeValid limits a games query to those which can be joined
+// by their code.
+func whereJoinCodeValid(q *gorm.DB) *gorm.DB {
+	q = q.Where("round = 1 AND phase = ?", GAME_PHASE_WAIT_FOR_READY)
+	return q.Where("created_at >= ?", time.Now().Add(-JOIN_CODE_MAX_AGE))
+}
+
+// assignJoinCode gives a new game a join code which no other game can be
+// joined by.
+func assignJoinCode(tx *gorm.DB, game *Game) error {
+	for x := 0; x < JOIN_CODE_ATTEMPTS; x++ {
+		code := generateJoinCode()
+		ok, err := claimJoinCode(tx, code)
+		if err != nil {
+			return err
+		}
+		if ok {
+			game.JoinCode = &code
+			return nil
+		}
+	}
+	return errors.New("no free join code found")
+}
+
+// claimJoinCode tells whether the code is free for a new game. Codes of
+// games which can't be joined by code anymore are taken from them, so
+// that the codes don't run out over time.
+func claimJoinCode(tx *gorm.DB, code string) (bool, error) {
+	var num uint64
+	err := whereJoinCodeValid(tx.Model(&Game{}).Where("join_code = ?", code)).Count(&num).Error
+	if err != nil || num != 0 {
+		return false, err
+	}
+	err = tx.Model(&Game{}).Where("join_code = ?", code).UpdateColumn("join_code", nil).Error
+	return err == nil, err
+}
+
+// findJoinCodeGame resolves a join code. Codes are short enough to be
+// guessed, so they only lead to games which can still be joined.
+func findJoinCodeGame(c *gin.Context) (Game, error) {
+	var game Game
+	code, ok := normalizeJoinCode(c.Param("code"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "invalid join code"})
+		return game, err4xx
+	}
+	err := whereJoinCodeValid(db.Where("join_code = ?", code)).First(&game).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(404, gin.H{"error": "invalid join code"})
+		return game, err4xx
+	}
+	return game, err
+}
+
+func getJoinCode(c *gin.Context) {
+	game, err := findJoinCodeGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find join code game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	c.JSON(200, gin.H{
+		"game_token": game.Token,
+		"join_code":  *game.JoinCode,
+	})
+}
+
+// redirectJoinCode sends people who typed or scanned a join code to the
+// game's join page.
+func redirectJoinCode(c *gin.Context) {
+	game, err := findJoinCodeGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find join code game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	c.Redirect(302, "/games/"+game.Token)
+}
+
+// getJoinURL returns the absolute URL behind the game's join code. It is
+// based on -baseURL if given. Otherwise, the request's Host header is
+// only used if the trusted proxy has sent it, as anybody else could make
+// it point elsewhere. false is returned if neither is available.
+func getJoinURL(c *gin.Context, game Game) (string, bool) {
+	path := "/j/" + *game.JoinCode
+	if baseURL != "" {
+		return strings.TrimSuffix(baseURL, "/") + path, true
+	}
+	if c.RemoteIP() != trustedProxy {
+		return "", false
+	}
+	scheme := "http"
+	if c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, c.Request.Host, path), true
+}
+
+// getVerifiedJoinURL is getJoinURL for handlers which can't do without.
+func getVerifiedJoinURL(c *gin.Context, game Game) (string, error) {
+	url, ok := getJoinURL(c, game)
+	if !ok {
+		c.JSON(404, gin.H{"error": "unknown join url"})
+		return "", err4xx
+	}
+	return url, nil
+}
+
+// getVerifiedInvitationGame finds the game for an invitation, which
+// requires a join code.
+func getVerifiedInvitationGame(c *gin.Context) (Game, error) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		return game, err
+	}
+	if game.JoinCode == nil {
+		// The game was started before join codes existed.
+		c.JSON(404, gin.H{"error": "game has no join code"})
+		return game, err4xx
+	}
+	return game, nil
+}
+
+func getInvitation(c *gin.Context) {
+	game, err := getVerifiedInvitationGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	invitation := gin.H{
+		"join_code": *game.JoinCode,
+	}
+	if url, ok := getJoinURL(c, game); ok {
+		invitation["join_url"] = url
+	}
+	c.JSON(200, invitation)
+}
+
+func getInvitationQRCodePNG(c *gin.Context) {
+	game, err := getVerifiedInvitationGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	url, err := getVerifiedJoinURL(c, game)
+	if err != nil {
+		return
+	}
+	png, err := qrcode.Encode(url, qrcode.Medium, QR_CODE_SIZE)
+	if err != nil {
+		log.Printf("failed to encode QR code: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.Data(200, "image/png", png)
+}
+
+func getInvitationQRCodeSVG(c *gin.Context) {
+	game, err := getVerifiedInvitationGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	url, err := getVerifiedJoinURL(c, game)
+	if err != nil {
+		return
+	}
+	q, err := qrcode.New(url, qrcode.Medium)
+	if err != nil {
+		log.Printf("failed to encode QR code: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.Data(200, "image/svg+xml", renderQRCodeSVG(q.Bitmap()))
+}
+
+// renderQRCodeSVG draws the dark modules as one path, one unit per
+// module, so that the image scales without blurring.
+func renderQRCodeSVG(bitmap [][]bool) []byte {
+	var b bytes.Buffer
+	size := len(bitmap)
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, size, size)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, size, size)
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if dark {
+				fmt.Fprintf(&b, "M%d %dh1v1h-1z", x, y)
+			}
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return b.Bytes()
+}
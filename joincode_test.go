@@ -0,0 +1,91 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJoinURL(t *testing.T) {
+	oldBaseURL, oldTrustedProxy := baseURL, trustedProxy
+	defer func() {
+		baseURL, trustedProxy = oldBaseURL, oldTrustedProxy
+	}()
+	code := "WHK-4821"
+	game := Game{JoinCode: &code}
+	trustedProxy = "10.0.0.1"
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		baseURL    string
+		remoteAddr string
+		url        string
+	}{
+		{"", "10.0.0.1:4711", "https://woadkwizz.example/j/WHK-4821"},
+		// Anybody else could send any Host:
+		{"", "10.0.0.2:4711", ""},
+		{"https://example.org/", "10.0.0.2:4711", "https://example.org/j/WHK-4821"},
+		{"https://example.org/quiz", "10.0.0.1:4711", "https://example.org/quiz/j/WHK-4821"},
+	}
+	for _, test := range tests {
+		baseURL = test.baseURL
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", "/", nil)
+		c.Request.Host = "woadkwizz.example"
+		c.Request.Header.Set("X-Forwarded-Proto", "https")
+		c.Request.RemoteAddr = test.remoteAddr
+		url, ok := getJoinURL(c, game)
+		if url != test.url || ok != (test.url != "") {
+			t.Errorf("getJoinURL with base URL %q from %s = %q, %v, want %q", test.baseURL, test.remoteAddr, url, ok, test.url)
+		}
+	}
+}
+
+func TestClaimJoinCode(t *testing.T) {
+	setupTestDB(t)
+	games := []struct {
+		code    string
+		phase   string
+		age     time.Duration
+		claimed bool
+	}{
+		{"AAA-2222", GAME_PHASE_WAIT_FOR_READY, 0, false},
+		{"HHH-2222", GAME_PHASE_WAIT_FOR_READY, 2 * JOIN_CODE_MAX_AGE, true},
+		{"JJJ-2222", GAME_PHASE_SUBMIT_WORD, 0, true},
+		{"KKK-2222", GAME_PHASE_GAME_OVER, 0, true},
+	}
+	for _, g := range games {
+		code := g.code
+		game := Game{Token: generateToken(), Round: 1, Phase: g.phase, JoinCode: &code}
+		err := db.Create(&game).Error
+		if err == nil {
+			err = db.Model(&game).UpdateColumn("created_at", time.Now().Add(-g.age)).Error
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, g := range games {
+		ok, err := claimJoinCode(db, g.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != g.claimed {
+			t.Errorf("claimJoinCode(%s) = %v, want %v", g.code, ok, g.claimed)
+		}
+		var num uint64
+		err = db.Model(&Game{}).Where("join_code = ?", g.code).Count(&num).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok && num != 0 {
+			t.Errorf("code %s was claimed but is still in use", g.code)
+		}
+	}
+	if ok, err := claimJoinCode(db, "LLL-2222"); !ok || err != nil {
+		t.Errorf("unused code can't be claimed: %v", err)
+	}
+}
@@ -2,62 +2,61 @@ package main
 
 import (
 	"math/rand"
+	"strconv"
+	"strings"
 )
 
-const ()
-
-var (
-	VOCALS                 = []rune("AAAEEEIIIOOOUUUÄÖÜY")
-	VOCALS_LEN             = len(VOCALS)
-	CONSONANTS             = []rune("BCDFGHJKLMNPQRSTVWXZ")
-	CONSONANTS_LEN         = len(CONSONANTS)
-	WORD_NUM_VOCALS        = 4
-	WORD_NUM_CONSONANTS    = 6
-	WORD_NUM_SPACES        = 2
-	WORD_NUM_LETTERS_TOTAL = WORD_NUM_VOCALS + WORD_NUM_CONSONANTS + WORD_NUM_SPACES
+const (
+	// WORD_NUM_* are the default letter counts of GameSettings.
+	WORD_NUM_VOCALS     = 4
+	WORD_NUM_CONSONANTS = 6
+	WORD_NUM_SPACES     = 2
+
+	// JOKER is the tile which can stand for any letter.
+	JOKER = '*'
 )
 
-func pickRandomLetters() string {
-	var runes []rune
-	for x := 0; x < WORD_NUM_VOCALS; x++ {
-		l := VOCALS[rand.Perm(VOCALS_LEN)[0]]
-		if howOftenUsed(l, runes) >= 2 {
-			// Don't let people draw the same letter more than twice,
-			// so, repeat the run.
-			x--
-			continue
-		}
-		runes = append(runes, rune(l))
-	}
+// pickRandomLetters deals the tiles for one card from the tile set of the
+// game's language, see formatTiles.
+func pickRandomLetters(settings GameSettings) string {
+	set := tileSets[settings.Language]
+	counts := make(map[string]int)
+	tiles := set.deal(TILE_VOWEL, settings.NumVocals, counts)
+	tiles = append(tiles, set.deal(TILE_CONSONANT, settings.NumConsonants, counts)...)
 
-	for x := 0; x < WORD_NUM_CONSONANTS; x++ {
-		l := CONSONANTS[rand.Perm(CONSONANTS_LEN)[0]]
-		if howOftenUsed(l, runes) >= 2 {
-			// Don't let people draw the same letter more than twice,
-			// so, repeat the run.
-			x--
-			continue
-		}
-		runes = append(runes, rune(l))
+	for x := 0; x < settings.NumSpaces; x++ {
+		tiles = append(tiles, "\u00a0") // non-breaking space
 	}
 
-	for x := 0; x < WORD_NUM_SPACES; x++ {
-		runes = append(runes, 0x00a0) // non-breaking space
+	for x := 0; x < settings.NumJokers; x++ {
+		tiles = append(tiles, string(JOKER))
 	}
 
-	rand.Shuffle(len(runes), func(i, j int) {
-		runes[i], runes[j] = runes[j], runes[i]
+	rand.Shuffle(len(tiles), func(i, j int) {
+		tiles[i], tiles[j] = tiles[j], tiles[i]
 	})
 
-	return string(runes)
+	return formatTiles(tiles)
+}
+
+// formatJokers encodes the positions of a word's letters which were
+// played as jokers for storing them along with the word.
+func formatJokers(jokers []int) string {
+	positions := make([]string, 0, len(jokers))
+	for _, pos := range jokers {
+		positions = append(positions, strconv.Itoa(pos))
+	}
+	return strings.Join(positions, ",")
 }
 
-func howOftenUsed(l rune, letters []rune) uint {
-	var count uint
-	for _, m := range letters {
-		if l == m {
-			count++
+// parseJokers decodes the positions stored by formatJokers.
+func parseJokers(s string) []int {
+	jokers := make([]int, 0)
+	for _, pos := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(pos)
+		if err == nil {
+			jokers = append(jokers, n)
 		}
 	}
-	return count
+	return jokers
 }
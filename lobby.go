@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// LOBBY_BROKER_ID is the broker id of lobby listeners. No game has
+	// the id 0.
+	LOBBY_BROKER_ID = 0
+	// LOBBY_MAX_AGE hides public games which have been waiting for
+	// players for too long.
+	LOBBY_MAX_AGE = 24 * time.Hour
+	// LOBBY_SIZE limits the number of listed games.
+	LOBBY_SIZE = 50
+)
+
+type jsonLobbyGame struct {
+	GameToken      string    `json:"game_token"`
+	JoinCode       *string   `json:"join_code"`
+	NumPlayers     uint64    `json:"num_players"`
+	MaxPlayers     uint      `json:"max_players"`
+	Language       string    `json:"language"`
+	CardSelection  string    `json:"card_selection"`
+	CardDifficulty *float64  `json:"card_difficulty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+type jsonLobby struct {
+	Games []jsonLobbyGame `json:"games"`
+}
+
+// getLobbyGames lists the joinable public games, the fullest first so
+// that tables get going quickly.
+func getLobbyGames(tx *gorm.DB) ([]jsonLobbyGame, error) {
+	var rows []struct {
+		Game
+		NumPlayers uint64
+	}
+	q := tx.Table("games")
+	q = q.Select("games.*, COUNT(players.id) AS num_players")
+	q = q.Joins("LEFT JOIN players ON players.game_id = games.id")
+	q = q.Where("games.public = 1 AND games.round = 1 AND games.phase = ?", GAME_PHASE_WAIT_FOR_READY)
+	q = q.Where("games.created_at >= ?", time.Now().Add(-LOBBY_MAX_AGE))
+	q = q.Group("games.id")
+	q = q.Having("games.max_players = 0 OR COUNT(players.id) < games.max_players")
+	q = q.Order("num_players DESC, games.created_at")
+	err := q.Limit(LOBBY_SIZE).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	games := make([]jsonLobbyGame, 0, len(rows))
+	for _, row := range rows {
+		selection := row.CardSelection
+		if selection == "" {
+			selection = cardSelection
+		}
+		games = append(games, jsonLobbyGame{
+			GameToken:      row.Token,
+			JoinCode:       row.JoinCode,
+			NumPlayers:     row.NumPlayers,
+			MaxPlayers:     row.MaxPlayers,
+			Language:       row.Language,
+			CardSelection:  selection,
+			CardDifficulty: row.CardDifficulty,
+			CreatedAt:      row.CreatedAt,
+		})
+	}
+	return games, nil
+}
+
+func getLobby(c *gin.Context) {
+	games, err := getLobbyGames(db)
+	if err != nil {
+		log.Printf("failed to query lobby: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, jsonLobby{Games: games})
+}
+
+func streamLobbyEvents(c *gin.Context) {
+	streamEvents(c, LOBBY_BROKER_ID, 0, "lobby")
+}
+
+func renderLobbyEvent(id uint64, playerID uint64) (string, bool) {
+	games, err := getLobbyGames(db)
+	if err != nil {
+		log.Printf("failed to query lobby for lobby event: %s", err)
+		return "", false
+	}
+	return renderEventJson(jsonLobby{Games: games})
+}
+
+// publishLobby announces changes of public games to lobby listeners.
+func publishLobby(game Game) {
+	if game.Public {
+		broker.Send(LOBBY_BROKER_ID, "lobby")
+	}
+}
+
+// quickJoinGame seats the player in the best-matching public game.
+func quickJoinGame(c *gin.Context) {
+	playerName, err := getVerifiedPlayerName(c)
+	if err != nil {
+		return
+	}
+	var options struct {
+		Language string `json:"language"`
+	}
+	c.ShouldBindBodyWith(&options, binding.JSON)
+
+	games, err := getLobbyGames(db)
+	if err != nil {
+		log.Printf("failed to query lobby: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	for _, lobbyGame := range games {
+		if options.Language != "" && lobbyGame.Language != options.Language {
+			continue
+		}
+		var game Game
+		err = db.First(&game, "token = ?", lobbyGame.GameToken).Error
+		if err != nil {
+			log.Printf("failed to find lobby game: %s", err)
+			c.AbortWithStatus(500)
+			return
+		}
+		player, err := addPlayer(c, game, playerName, false)
+		if _, ok := err.(actionError); ok {
+			// Started, filled up or name taken in the meantime:
+			continue
+		}
+		if err != nil {
+			log.Printf("addPlayer failed: %s", err)
+			c.AbortWithStatus(500)
+			return
+		}
+		respondJoined(c, game, player)
+		return
+	}
+	c.JSON(404, gin.H{"error": "no open public game"})
+}
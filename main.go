@@ -4,10 +4,12 @@ import (
 	"bufio"
 	"encoding/base64"
 	"flag"
+	"fmt"
 	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -20,22 +22,44 @@ import (
 
 var db *gorm.DB
 var router *gin.Engine
-var broker *Broker
+var broker Broker
 
 var cardsPath string
+var tilesPath string
+var dictionaryPath string
 var dbPath string
 var assetsPath string
 var listen string
 var trustedProxy string
+var baseURL string
 var debug bool
+var sessionCookies bool
+var sessionSecret string
+var bareEvents bool
+var brokerURL string
+var dumpGameLogToken string
+var rebuildGameToken string
+var adminToken string
+var cardSelection string
 
 func init() {
 	flag.StringVar(&cardsPath, "cardsPath", "cards.b64", "path to the file containing card texts")
+	flag.StringVar(&tilesPath, "tilesPath", "./tiles", "path to the directory containing the tile sets, one <language>.txt each")
+	flag.StringVar(&dictionaryPath, "dictionaryPath", "./dictionaries", "path to the directory containing word lists for banning real words, one <language>.txt each")
 	flag.StringVar(&dbPath, "dbPath", "game.sqlite", "path to the file containing sqlite database")
 	flag.StringVar(&assetsPath, "assetsPath", "./ui", "path to the directory containing static files")
 	flag.StringVar(&listen, "listen", "127.0.0.1:3000", "host:port to listen on")
 	flag.StringVar(&trustedProxy, "trustedProxy", "127.0.0.1", "ip address of the reverse proxy in front of woadkwizz ")
+	flag.StringVar(&baseURL, "baseURL", "", "public URL of woadkwizz for invitation links and QR codes, e.g. https://example.org (taken from the requests of -trustedProxy if empty)")
 	flag.BoolVar(&debug, "debug", false, "whether to enable debugging features")
+	flag.BoolVar(&sessionCookies, "sessionCookies", false, "whether to hand out player credentials as signed session cookies instead of URL tokens")
+	flag.StringVar(&brokerURL, "brokerURL", "", "pubsub service shared by several instances, e.g. redis://host:6379/channel or loopback:// for testing (in-process if empty)")
+	flag.BoolVar(&bareEvents, "bareEvents", false, "whether to additionally send payload-less board events to game-wide listeners (compatibility)")
+	flag.StringVar(&sessionSecret, "sessionSecret", "", "secret for signing session cookies (random if empty)")
+	flag.StringVar(&cardSelection, "cardSelection", CARD_SELECTION_LEAST_USED, "default card selection strategy: least-used, random, category, difficulty or avoid-seen")
+	flag.StringVar(&adminToken, "adminToken", "", "token for the admin API (disabled if empty)")
+	flag.StringVar(&dumpGameLogToken, "dumpGameLog", "", "game token whose event log should be written to stdout before exiting")
+	flag.StringVar(&rebuildGameToken, "rebuildGame", "", "game token whose players, words and guesses should be rebuilt from its event log before exiting, printing the players' new links")
 }
 
 func main() {
@@ -43,10 +67,28 @@ func main() {
 
 	flag.Parse()
 
-	broker = NewBroker()
+	if _, ok := cardSelectors[cardSelection]; !ok {
+		log.Fatalf("unknown card selection strategy %q", cardSelection)
+	}
+	err := loadTileSets()
+	if err != nil {
+		log.Fatalf("failed to load tile sets, see -tilesPath: %s", err)
+	}
+	err = loadDictionaries()
+	if err != nil {
+		log.Fatalf("failed to load dictionaries: %s", err)
+	}
+	setupSessions()
+	broker, err = newBroker(brokerURL)
+	if err != nil {
+		log.Fatalf("failed to set up broker: %s", err)
+	}
+	setupEventRenderers()
 
-	var err error
-	db, err = gorm.Open("sqlite3", dbPath)
+	// Let concurrent writers wait for each other instead of failing, and
+	// take the write lock right at the start of a transaction so that
+	// transactions can't deadlock when upgrading their locks:
+	db, err = gorm.Open("sqlite3", dbPath+"?_busy_timeout=5000&_txlock=immediate")
 	if err != nil {
 		panic("failed to connect database")
 	}
@@ -56,8 +98,42 @@ func main() {
 	db.AutoMigrate(&Card{})
 	db.AutoMigrate(&Word{})
 	db.AutoMigrate(&Guess{})
+	db.AutoMigrate(&GameEvent{})
+	db.AutoMigrate(&Profile{})
+	db.AutoMigrate(&Room{})
+	err = migrateGamePhases()
+	if err != nil {
+		log.Fatalf("failed to migrate game phases: %s", err)
+	}
+	err = migrateGameEventTokens()
+	if err != nil {
+		log.Fatalf("failed to remove tokens from game logs: %s", err)
+	}
+
+	if dumpGameLogToken != "" {
+		err = dumpGameLog(os.Stdout, dumpGameLogToken)
+		if err != nil {
+			log.Fatalf("failed to dump game log: %s", err)
+		}
+		return
+	}
+	if rebuildGameToken != "" {
+		players, err := rebuildGame(rebuildGameToken)
+		if err != nil {
+			log.Fatalf("failed to rebuild game: %s", err)
+		}
+		// The old links are gone, hand out the new ones:
+		for _, player := range players {
+			fmt.Printf("%s\t/games/%s/players/%s\n", player.Name, rebuildGameToken, player.Token)
+		}
+		return
+	}
 
 	importBaseCards()
+	err = backfillCardStats()
+	if err != nil {
+		log.Fatalf("failed to update card stats: %s", err)
+	}
 
 	if !debug {
 		gin.SetMode(gin.ReleaseMode)
@@ -71,17 +147,56 @@ func main() {
 	router.StaticFile("/", filepath.Join(assetsPath, "index.html"))
 	router.GET("/games/:game_token", serveIndex)
 	router.GET("/games/:game_token/players/:player_token", serveIndex)
+	router.GET("/games/:game_token/play", serveIndex)
+	router.GET("/games/:game_token/tv", serveIndex)
+	router.GET("/j/:code", redirectJoinCode)
 	router.Static("/ui", assetsPath)
 	router.POST("/api/games", startNewGame)
 	router.GET("/api/games/:game_token/events", streamGameEvents)
+	router.GET("/api/games/:game_token/ws", serveGameWebSocket)
 	router.POST("/api/games/:game_token/players", joinGame)
 	router.GET("/api/games/:game_token/players", getPlayerList)
+	router.GET("/api/games/:game_token/settings", getSettings)
+	router.GET("/api/games/:game_token/board", getPublicBoard)
+	router.GET("/api/lobby", getLobby)
+	router.GET("/api/lobby/events", streamLobbyEvents)
+	router.POST("/api/lobby/join", quickJoinGame)
+	router.GET("/api/join-codes/:code", getJoinCode)
+	router.GET("/api/games/:game_token/invitation", getInvitation)
+	router.GET("/api/games/:game_token/invitation/qr.png", getInvitationQRCodePNG)
+	router.GET("/api/games/:game_token/invitation/qr.svg", getInvitationQRCodeSVG)
+	router.POST("/api/profiles", createProfile)
+	router.GET("/api/profile", getOwnProfile)
+	router.DELETE("/api/profile/seen-cards", resetProfileSeenCards)
+	router.POST("/api/rooms", createRoom)
+	router.GET("/api/rooms/:room_token", getRoom)
+	router.DELETE("/api/rooms/:room_token/seen-cards", resetRoomSeenCards)
+	router.GET("/api/profiles/:profile_id/stats", getProfileStats)
+	router.GET("/api/leaderboard", getLeaderboard)
+	router.GET("/api/admin/cards/report", getCardReport)
+	router.GET("/api/games/:game_token/rounds", getRounds)
+	router.GET("/api/games/:game_token/rounds/:round", getRound)
 	router.PUT("/api/games/:game_token/players/:player_token/ready", markPlayerReady)
 	router.GET("/api/games/:game_token/players/:player_token", getBoard)
+	router.GET("/api/games/:game_token/players/:player_token/events", streamPlayerEvents)
 	router.PUT("/api/games/:game_token/players/:player_token/word", submitWord)
+	router.PUT("/api/games/:game_token/players/:player_token/mulligan", takeMulligan)
 	router.GET("/api/games/:game_token/players/:player_token/guesses", getGuesses)
 	router.PUT("/api/games/:game_token/players/:player_token/guesses", submitGuesses)
 	router.PUT("/api/games/:game_token/players/:player_token/scored", markScored)
+	router.PUT("/api/games/:game_token/players/:player_token/profile", linkPlayerProfile)
+	router.PATCH("/api/games/:game_token/players/:player_token/settings", updateSettings)
+	// The same player routes, but authenticated via session cookie:
+	router.GET("/api/games/:game_token/self", getBoard)
+	router.GET("/api/games/:game_token/self/events", streamPlayerEvents)
+	router.PUT("/api/games/:game_token/self/ready", markPlayerReady)
+	router.PUT("/api/games/:game_token/self/word", submitWord)
+	router.PUT("/api/games/:game_token/self/mulligan", takeMulligan)
+	router.GET("/api/games/:game_token/self/guesses", getGuesses)
+	router.PUT("/api/games/:game_token/self/guesses", submitGuesses)
+	router.PUT("/api/games/:game_token/self/scored", markScored)
+	router.PUT("/api/games/:game_token/self/profile", linkPlayerProfile)
+	router.PATCH("/api/games/:game_token/self/settings", updateSettings)
 	router.Run(listen)
 }
 
@@ -101,9 +216,15 @@ func importBaseCards() {
 		// usage of a Transaction is important for performance as
 		// multiple INSERTs will take a lot of time otherwise.
 		for scanner.Scan() {
+			// Lines may assign a category after a tab:
+			fields := strings.SplitN(scanner.Text(), "\t", 2)
 			var c Card
-			card := Card{Text: scanner.Text()}
-			err = tx.Where(card).FirstOrCreate(&c).Error
+			card := Card{Text: fields[0]}
+			q := tx.Where(card)
+			if len(fields) == 2 {
+				q = q.Assign(Card{Category: fields[1]})
+			}
+			err = q.FirstOrCreate(&c).Error
 			if err != nil {
 				return err
 			}
@@ -2,45 +2,73 @@ package main
 
 import (
 	"time"
+
+	"github.com/jinzhu/gorm"
 )
 
 const (
 	// GAME_PHASE_* is used to denote different situations.
-	// These values are calculated and not saved in the db.
-	// They are returned by Game.GetPhase()
+	// They are saved in Game.Phase and only changed by advancePhase().
 	GAME_PHASE_WAIT_FOR_READY = "wait-for-ready"
 	GAME_PHASE_SUBMIT_WORD    = "submit-word"
 	GAME_PHASE_ASSIGN_WORDS   = "assign-words"
 	GAME_PHASE_SCORE          = "score"
+	GAME_PHASE_GAME_OVER      = "game-over"
+
+	// GAME_MIN_PLAYERS is needed for the game to work, GAME_MAX_PLAYERS
+	// is the highest min_players and max_players setting.
+	GAME_MIN_PLAYERS = 3
+	GAME_MAX_PLAYERS = 20
+
+	GAME_LANGUAGE_DEFAULT = "de"
 )
 
+// gameLanguages lists the languages which cards and letters exist for.
+var gameLanguages = map[string]bool{
+	GAME_LANGUAGE_DEFAULT: true,
+}
+
 type Card struct {
-	ID   uint64
-	Text string `gorm:"unique; not null"`
+	ID       uint64
+	Text     string `gorm:"unique; not null"`
+	Category string
+	// Learned from finished rounds by updateCardStats():
+	TimesDealt   uint64
+	TimesGuessed uint64
+	TimesMissed  uint64
+	TimesDecoy   uint64
+	Difficulty   float64 `gorm:"not null; default:0.5"`
 }
 
 type Game struct {
 	ID        uint64
 	Token     string `gorm:"unique; not null"`
 	Round     int64
+	Phase     string
+	Version   int64 // Incremented on every board change.
 	CreatedAt time.Time
+	GameSettings
+	// RoomID links games of the same group, if any.
+	RoomID *uint64 `gorm:"index"`
+	// JoinCode is a short code for inviting people, e.g. WHK-4821.
+	JoinCode *string `gorm:"unique_index"`
 }
 
-func (g Game) GetPhase() (string, error) {
-	playersReady, err := g.PlayersReady()
+// DerivePhase works out the phase from the game's players, words and
+// guesses. It is only used for checking the consistency of Phase and for
+// migrating games which were created before it existed.
+func (g Game) DerivePhase(tx *gorm.DB) (string, error) {
+	if g.IsOver() {
+		return GAME_PHASE_GAME_OVER, nil
+	}
+	playersReady, err := g.PlayersReady(tx)
 	if err != nil {
 		return "", err
 	}
 	if !playersReady {
 		return GAME_PHASE_WAIT_FOR_READY, nil
 	}
-	var numUnsubmittedWords uint64
-	q := db.Table("players")
-	q = q.Joins("LEFT JOIN words ON words.player_id = players.id AND words.game_id = players.game_id AND words.round = players.round")
-	q = q.Where("players.game_id = ?", g.ID)
-	q = q.Where("words.word = ''")
-	q = q.Count(&numUnsubmittedWords)
-	err = q.Error
+	numUnsubmittedWords, err := g.NumUnsubmittedWords(tx)
 	if err != nil {
 		return "", err
 	}
@@ -48,19 +76,10 @@ func (g Game) GetPhase() (string, error) {
 		return GAME_PHASE_SUBMIT_WORD, nil
 	}
 
-	var numPlayersWithUnassignedWords uint64
-	q = db.Table("players")
-	q = q.Select("players.*, COUNT(guesses.id) as guess_count")
-	q = q.Joins("LEFT JOIN guesses ON guesses.game_id = players.game_id AND guesses.round = players.round AND guesses.player_id = players.id")
-	q = q.Where("players.game_id = ?", g.ID)
-	q = q.Group("players.id, guesses.player_id")
-	q = q.Having("guess_count <> (SELECT COUNT(all_players.id)-1 FROM players AS all_players WHERE all_players.game_id = players.game_id)")
-	q = q.Count(&numPlayersWithUnassignedWords)
-	err = q.Error
+	numPlayersWithUnassignedWords, err := g.NumPlayersWithUnassignedWords(tx)
 	if err != nil {
 		return "", err
 	}
-
 	if numPlayersWithUnassignedWords != 0 {
 		return GAME_PHASE_ASSIGN_WORDS, nil
 	}
@@ -68,25 +87,68 @@ func (g Game) GetPhase() (string, error) {
 	return GAME_PHASE_SCORE, nil
 }
 
-func (g Game) PlayersReady() (bool, error) {
+func (g Game) NumUnsubmittedWords(tx *gorm.DB) (uint64, error) {
+	var numUnsubmittedWords uint64
+	q := tx.Table("players")
+	q = q.Joins("LEFT JOIN words ON words.player_id = players.id AND words.game_id = players.game_id AND words.round = players.round")
+	q = q.Where("players.game_id = ?", g.ID)
+	q = q.Where("words.word = ''")
+	q = q.Count(&numUnsubmittedWords)
+	return numUnsubmittedWords, q.Error
+}
+
+func (g Game) NumPlayersWithUnassignedWords(tx *gorm.DB) (uint64, error) {
+	var numPlayersWithUnassignedWords uint64
+	q := tx.Table("players")
+	q = q.Select("players.*, COUNT(guesses.id) as guess_count")
+	q = q.Joins("LEFT JOIN guesses ON guesses.game_id = players.game_id AND guesses.round = players.round AND guesses.player_id = players.id")
+	q = q.Where("players.game_id = ? AND players.queued = 0", g.ID)
+	q = q.Group("players.id, guesses.player_id")
+	q = q.Having("guess_count <> (SELECT COUNT(all_players.id)-1 FROM players AS all_players WHERE all_players.game_id = players.game_id AND all_players.queued = 0)")
+	q = q.Count(&numPlayersWithUnassignedWords)
+	return numPlayersWithUnassignedWords, q.Error
+}
+
+func (g Game) NumUnscoredWords(tx *gorm.DB) (uint64, error) {
+	var numUnscoredWords uint64
+	q := tx.Table("words")
+	q = q.Where("game_id = ?", g.ID)
+	q = q.Where("player_id IS NOT NULL")
+	q = q.Where("is_scored = 0")
+	q = q.Count(&numUnscoredWords)
+	return numUnscoredWords, q.Error
+}
+
+func (g Game) PlayersReady(tx *gorm.DB) (bool, error) {
 	var numPlayers uint64
-	err := db.Table("players").Where("game_id = ?", g.ID).Count(&numPlayers).Error
+	err := tx.Table("players").Where("game_id = ? AND queued = 0", g.ID).Count(&numPlayers).Error
 	if err != nil {
 		return false, err
 	}
-	if numPlayers < 3 {
-		// Game doesn't work with less than 3 players.
+	if numPlayers < uint64(g.MinPlayers) {
 		return false, nil
 	}
 
 	var numNonreadyPlayers uint64
-	err = db.Table("players").Where("game_id = ?", g.ID).Where("round < ?", g.Round).Count(&numNonreadyPlayers).Error
+	err = tx.Table("players").Where("game_id = ? AND queued = 0", g.ID).Where("round < ?", g.Round).Count(&numNonreadyPlayers).Error
 	if err != nil {
 		return false, err
 	}
 	return numNonreadyPlayers == 0, err
 }
 
+// IsOver tells whether the game's last round has been played.
+func (g Game) IsOver() bool {
+	return g.MaxRounds != 0 && g.Round > g.MaxRounds
+}
+
+// Host returns the player who has started the game.
+func (g Game) Host(tx *gorm.DB) (Player, error) {
+	var host Player
+	err := tx.Where("game_id = ?", g.ID).Order("id").First(&host).Error
+	return host, err
+}
+
 type Player struct {
 	ID     uint64
 	GameID uint64 `gorm:"unique_index:idx_gameid_name; not null"`
@@ -95,6 +157,14 @@ type Player struct {
 	Name   string `gorm:"unique_index:idx_gameid_name; not null"`
 	Round  int64
 	Word   Word `gorm:"association_foreignkey:PlayerID,GameID,Round"`
+	// ProfileID links the seat to a persistent profile, if any.
+	ProfileID *uint64 `gorm:"index"`
+	// Queued players have joined a running game. They are seated by
+	// seatQueuedPlayers() when the next round starts.
+	Queued bool `gorm:"not null; default:0"`
+	// FirstRound is the round in which the player has been dealt a card
+	// first.
+	FirstRound int64 `gorm:"not null; default:1"`
 }
 
 type Word struct {
@@ -102,11 +172,18 @@ type Word struct {
 	GameID   uint64 `gorm:"unique_index:idx_gameid_cardid; not null"`
 	Game     Game
 	Round    int64
-	CardID   uint64 `gorm:"unique_index:idx_gameid_cardid; not null"`
+	CardID   uint64 `gorm:"unique_index:idx_gameid_cardid; index; not null"`
 	Card     Card
 	PlayerID *uint64 // Will be NULL in case of the additional card.
 	Word     string
 	Letters  string
+	// Jokers lists the positions of Word's letters which were played as
+	// jokers, see formatJokers.
+	Jokers string `gorm:"not null; default:''"`
+	// JokerPenalty is the number of points the jokers cost the word.
+	JokerPenalty int `gorm:"not null; default:0"`
+	// Redraws counts how often the player has redrawn the letters.
+	Redraws  int `gorm:"not null; default:0"`
 	IsScored bool
 	Guesses  []Guess
 }
@@ -117,8 +194,8 @@ type Guess struct {
 	Round    int64  `gorm:"unique_index:idx_gameid_round_playerid_wordid_cardid; not null"`
 	PlayerID uint64 `gorm:"unique_index:idx_gameid_round_playerid_wordid_cardid; not null"`
 	Player   Player
-	WordID   uint64 `gorm:"unique_index:idx_gameid_round_playerid_wordid_cardid; not null"`
+	WordID   uint64 `gorm:"unique_index:idx_gameid_round_playerid_wordid_cardid; index; not null"`
 	Word     Word
-	CardID   uint64 `gorm:"unique_index:idx_gameid_round_playerid_wordid_cardid; not null"`
+	CardID   uint64 `gorm:"unique_index:idx_gameid_round_playerid_wordid_cardid; index; not null"`
 	Card     Card
 }
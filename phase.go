@@ -0,0 +1,222 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// gameLock serializes the actions of one game.
+type gameLock struct {
+	sync.Mutex
+	refs int
+}
+
+var (
+	gameLocksMu sync.Mutex
+	gameLocks   = make(map[uint64]*gameLock)
+)
+
+// lockGame waits until no other action of the game is in progress within
+// this instance. Instances sharing the database are kept in check by the
+// conditional update in advancePhase.
+func lockGame(gameID uint64) (unlock func()) {
+	gameLocksMu.Lock()
+	l, ok := gameLocks[gameID]
+	if !ok {
+		l = &gameLock{}
+		gameLocks[gameID] = l
+	}
+	l.refs++
+	gameLocksMu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		gameLocksMu.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(gameLocks, gameID)
+		}
+		gameLocksMu.Unlock()
+	}
+}
+
+// errPhaseChanged aborts a transition whose game has left the transition's
+// initial phase in the meantime.
+var errPhaseChanged = errors.New("game phase has changed")
+
+// phaseTransition describes how a game leaves the phase it's in.
+type phaseTransition struct {
+	to string
+	// next replaces to if set, depending on the game after apply.
+	next func(game *Game) string
+	// ready checks the preconditions of the transition.
+	ready func(tx *gorm.DB, game *Game) (bool, error)
+	// apply makes the changes which go along with the transition.
+	apply func(tx *gorm.DB, game *Game) error
+}
+
+var phaseTransitions = map[string]phaseTransition{
+	GAME_PHASE_WAIT_FOR_READY: {
+		to:    GAME_PHASE_SUBMIT_WORD,
+		ready: allPlayersReady,
+		apply: startNewRound,
+	},
+	GAME_PHASE_SUBMIT_WORD: {
+		to:    GAME_PHASE_ASSIGN_WORDS,
+		ready: allWordsSubmitted,
+	},
+	GAME_PHASE_ASSIGN_WORDS: {
+		to:    GAME_PHASE_SCORE,
+		ready: allWordsAssigned,
+	},
+	GAME_PHASE_SCORE: {
+		next:  nextRoundOrGameOver,
+		ready: allWordsScored,
+		apply: finishRound,
+	},
+}
+
+// nextRoundOrGameOver ends the game after its last round.
+func nextRoundOrGameOver(game *Game) string {
+	if game.IsOver() {
+		return GAME_PHASE_GAME_OVER
+	}
+	return GAME_PHASE_WAIT_FOR_READY
+}
+
+func allPlayersReady(tx *gorm.DB, game *Game) (bool, error) {
+	return game.PlayersReady(tx)
+}
+
+func allWordsSubmitted(tx *gorm.DB, game *Game) (bool, error) {
+	num, err := game.NumUnsubmittedWords(tx)
+	return num == 0, err
+}
+
+func allWordsAssigned(tx *gorm.DB, game *Game) (bool, error) {
+	num, err := game.NumPlayersWithUnassignedWords(tx)
+	return num == 0, err
+}
+
+func allWordsScored(tx *gorm.DB, game *Game) (bool, error) {
+	num, err := game.NumUnscoredWords(tx)
+	return num == 0, err
+}
+
+func finishRound(tx *gorm.DB, game *Game) error {
+	q := tx.Model(&Game{}).Where("id = ? AND round = ?", game.ID, game.Round)
+	q = q.UpdateColumn("round", game.Round+1)
+	if q.Error != nil {
+		return q.Error
+	}
+	if q.RowsAffected != 1 {
+		return errPhaseChanged
+	}
+	err := updateRoundCardStats(tx, game)
+	if err != nil {
+		return fmt.Errorf("failed to update card stats: %s", err)
+	}
+	game.Round++
+	return nil
+}
+
+// advancePhase moves the game on to its next phase if the preconditions
+// are met. The transition and its changes are saved in one transaction
+// which fails if another request has changed the phase first. Listeners
+// are notified with a phase_changed event.
+func advancePhase(game *Game) (bool, error) {
+	if game.Phase == GAME_PHASE_GAME_OVER {
+		return false, nil
+	}
+	transition, ok := phaseTransitions[game.Phase]
+	if !ok {
+		return false, fmt.Errorf("game %d has unknown phase %q", game.ID, game.Phase)
+	}
+
+	next := *game
+	changed := false
+	err := db.Transaction(func(tx *gorm.DB) error {
+		ready, err := transition.ready(tx, &next)
+		if err != nil || !ready {
+			return err
+		}
+		if transition.apply != nil {
+			err = transition.apply(tx, &next)
+			if err != nil {
+				return err
+			}
+		}
+		to := transition.to
+		if transition.next != nil {
+			to = transition.next(&next)
+		}
+		q := tx.Model(&Game{}).Where("id = ? AND phase = ?", game.ID, game.Phase)
+		q = q.UpdateColumn("phase", to)
+		if q.Error != nil {
+			return q.Error
+		}
+		if q.RowsAffected != 1 {
+			return errPhaseChanged
+		}
+		next.Phase = to
+		changed = true
+		return nil
+	})
+	if err == errPhaseChanged {
+		// Somebody else was faster, which is just as good.
+		return false, nil
+	}
+	if err != nil || !changed {
+		return false, err
+	}
+
+	*game = next
+	broker.Send(game.ID, "phase_changed")
+	if game.Round == 1 && game.Phase == GAME_PHASE_SUBMIT_WORD {
+		// The game has started and is no longer joinable:
+		publishLobby(*game)
+	}
+	if debug {
+		checkPhase(*game)
+	}
+	return true, nil
+}
+
+// checkPhase logs games whose stored phase differs from the one derived
+// from their players, words and guesses.
+func checkPhase(game Game) {
+	derived, err := game.DerivePhase(db)
+	if err != nil {
+		log.Printf("failed to derive phase of game %d: %s", game.ID, err)
+		return
+	}
+	if derived != game.Phase {
+		log.Printf("game %d is in phase %s but should be in %s", game.ID, game.Phase, derived)
+	}
+}
+
+// migrateGamePhases sets the phase of games which were started before it
+// was saved.
+func migrateGamePhases() error {
+	var games []Game
+	err := db.Where("phase = '' OR phase IS NULL").Find(&games).Error
+	if err != nil {
+		return err
+	}
+	for _, game := range games {
+		phase, err := game.DerivePhase(db)
+		if err != nil {
+			return err
+		}
+		err = db.Model(&game).UpdateColumn("phase", phase).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
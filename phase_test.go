@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestPhaseMatchesDerivedPhase(t *testing.T) {
+	setupTestDB(t)
+	game, players := createTestGame(t)
+	err := db.Model(&game).UpdateColumn("max_rounds", 2).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	var phases []string
+	check := func(game Game) {
+		derived, err := game.DerivePhase(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if derived != game.Phase {
+			t.Errorf("game is in phase %s of round %d but should be in %s", game.Phase, game.Round, derived)
+		}
+		if len(phases) == 0 || phases[len(phases)-1] != game.Phase {
+			phases = append(phases, game.Phase)
+		}
+	}
+	check(game)
+	game = playCheckedTestRound(t, game, players, check)
+	game = playCheckedTestRound(t, game, players, check)
+
+	want := []string{
+		GAME_PHASE_WAIT_FOR_READY, GAME_PHASE_SUBMIT_WORD, GAME_PHASE_ASSIGN_WORDS, GAME_PHASE_SCORE,
+		GAME_PHASE_WAIT_FOR_READY, GAME_PHASE_SUBMIT_WORD, GAME_PHASE_ASSIGN_WORDS, GAME_PHASE_SCORE,
+		GAME_PHASE_GAME_OVER,
+	}
+	if len(phases) != len(want) {
+		t.Fatalf("game went through phases %v, want %v", phases, want)
+	}
+	for x := range want {
+		if phases[x] != want[x] {
+			t.Fatalf("game went through phases %v, want %v", phases, want)
+		}
+	}
+}
+
+// startTestGame creates a game whose players are all ready.
+func startTestGame(t *testing.T) (Game, []Player) {
+	game, players := createTestGame(t)
+	for _, player := range players {
+		err := setPlayerReady(player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reloadTestGame(t, game), players
+}
+
+func TestMigrateGamePhases(t *testing.T) {
+	setupTestDB(t)
+	var games []Game
+
+	waiting, _ := createTestGame(t)
+	games = append(games, waiting)
+
+	submitting, players := startTestGame(t)
+	submitTestWord(t, submitting, players[0])
+	games = append(games, submitting)
+
+	assigning, players := startTestGame(t)
+	for _, player := range players {
+		submitTestWord(t, assigning, player)
+	}
+	games = append(games, reloadTestGame(t, assigning))
+
+	scoring, players := startTestGame(t)
+	for _, player := range players {
+		submitTestWord(t, scoring, player)
+	}
+	for _, player := range players {
+		guessTestWords(t, scoring, player)
+	}
+	scoring = reloadTestGame(t, scoring)
+	word, err := getCurrentlyScoredWord(scoring)
+	if err == nil {
+		err = scoreCurrentWord(Player{ID: *word.PlayerID, GameID: scoring.ID})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	games = append(games, reloadTestGame(t, scoring))
+
+	between, players := createTestGame(t)
+	games = append(games, playTestRound(t, between, players))
+
+	over, players := createTestGame(t)
+	err = db.Model(&over).UpdateColumn("max_rounds", 1).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	games = append(games, playTestRound(t, over, players))
+
+	covered := make(map[string]bool)
+	for _, game := range games {
+		covered[game.Phase] = true
+	}
+	if len(covered) != 5 {
+		t.Fatalf("games only cover the phases %v", covered)
+	}
+
+	// The column was added empty to existing games:
+	for x, game := range games {
+		var phase interface{}
+		if x%2 == 0 {
+			phase = ""
+		}
+		err = db.Model(&Game{}).Where("id = ?", game.ID).UpdateColumn("phase", phase).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = migrateGamePhases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, game := range games {
+		migrated := reloadTestGame(t, game)
+		if migrated.Phase != game.Phase {
+			t.Errorf("game in phase %s of round %d was migrated to %q", game.Phase, game.Round, migrated.Phase)
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	PROFILE_COOKIE_NAME    = "woadkwizz_profile"
+	PROFILE_COOKIE_MAX_AGE = 60 * 60 * 24 * 365
+)
+
+// Profile is an optional identity which outlives games. It is bound to a
+// device by a cookie and linked to the players it has been seated as.
+type Profile struct {
+	ID        uint64
+	Token     string `gorm:"unique; not null"`
+	Name      string `gorm:"not null"`
+	CreatedAt time.Time
+	// SeenResetAt is when the profile last started over with all cards.
+	SeenResetAt *time.Time
+}
+
+type jsonProfile struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+func createProfile(c *gin.Context) {
+	var p struct {
+		Name string `json:"profile_name" binding:"required"`
+	}
+	if err := c.BindJSON(&p); err != nil {
+		c.JSON(400, gin.H{"error": "missing profile_name"})
+		return
+	}
+	if !PLAYER_NAME_RE.MatchString(p.Name) {
+		c.JSON(400, gin.H{"error": "invalid profile_name"})
+		return
+	}
+
+	profile := Profile{
+		Token: generateToken(),
+		Name:  p.Name,
+	}
+	err := db.Create(&profile).Error
+	if err != nil {
+		log.Printf("failed to create profile: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	setProfileCookie(c, profile)
+	c.JSON(201, jsonProfile{ID: profile.ID, Name: profile.Name})
+}
+
+func getOwnProfile(c *gin.Context) {
+	profile, ok := getCookieProfile(c)
+	if !ok {
+		c.JSON(404, gin.H{"error": "no profile"})
+		return
+	}
+	c.JSON(200, jsonProfile{ID: profile.ID, Name: profile.Name})
+}
+
+// resetProfileSeenCards lets the profile's next games use all cards
+// again.
+func resetProfileSeenCards(c *gin.Context) {
+	profile, ok := getCookieProfile(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "missing profile"})
+		return
+	}
+	err := db.Model(&profile).UpdateColumn("seen_reset_at", time.Now()).Error
+	if err != nil {
+		log.Printf("failed to reset seen cards: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, nil)
+}
+
+// linkPlayerProfile links an existing seat to the device's profile.
+func linkPlayerProfile(c *gin.Context) {
+	player, err := getVerifiedPlayer(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("getVerifiedPlayer failed: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	profile, ok := getCookieProfile(c)
+	if !ok {
+		c.JSON(401, gin.H{"error": "missing profile"})
+		return
+	}
+	if player.ProfileID != nil {
+		if *player.ProfileID == profile.ID {
+			c.JSON(200, nil)
+			return
+		}
+		c.JSON(409, gin.H{"error": "player is linked to another profile"})
+		return
+	}
+
+	errAlreadySeated := errors.New("profile already seated")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		profileID, err := getLinkableProfileID(tx, player.GameID, profile)
+		if err != nil {
+			return err
+		}
+		if profileID == nil {
+			return errAlreadySeated
+		}
+		err = tx.Model(&player).UpdateColumn("profile_id", profile.ID).Error
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, player.Game, &player.ID, GAME_EVENT_PROFILE_LINKED, gameEventProfileLinked{
+			ProfileID: profile.ID,
+		})
+	})
+	if err == errAlreadySeated {
+		c.JSON(409, gin.H{"error": "profile is linked to another player of this game"})
+		return
+	}
+	if err != nil {
+		log.Printf("failed to link profile: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, nil)
+}
+
+// getProfileIDForNewPlayer returns the id of the device's profile for
+// linking it to a new player, or nil if there is nothing to link.
+func getProfileIDForNewPlayer(c *gin.Context, tx *gorm.DB, gameID uint64) (*uint64, error) {
+	profile, ok := getCookieProfile(c)
+	if !ok {
+		return nil, nil
+	}
+	return getLinkableProfileID(tx, gameID, profile)
+}
+
+// getLinkableProfileID returns the profile's id unless the profile already
+// has a seat in the game.
+func getLinkableProfileID(tx *gorm.DB, gameID uint64, profile Profile) (*uint64, error) {
+	var num uint64
+	err := tx.Model(&Player{}).Where("game_id = ? AND profile_id = ?", gameID, profile.ID).Count(&num).Error
+	if err != nil || num != 0 {
+		return nil, err
+	}
+	return &profile.ID, nil
+}
+
+func setProfileCookie(c *gin.Context, profile Profile) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(PROFILE_COOKIE_NAME, profile.Token, PROFILE_COOKIE_MAX_AGE, "/", "", secure, true)
+}
+
+func getCookieProfile(c *gin.Context) (Profile, bool) {
+	var profile Profile
+	token, err := c.Cookie(PROFILE_COOKIE_NAME)
+	if err != nil || token == "" {
+		return profile, false
+	}
+	err = db.First(&profile, "token = ?", token).Error
+	if err != nil {
+		if err != gorm.ErrRecordNotFound {
+			log.Printf("failed to find profile: %s", err)
+		}
+		return profile, false
+	}
+	return profile, true
+}
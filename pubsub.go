@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PUBSUB_DIAL_TIMEOUT    = 5 * time.Second
+	PUBSUB_RECONNECT_DELAY = time.Second
+	PUBSUB_DEFAULT_CHANNEL = "woadkwizz"
+)
+
+// PubSub is a message transport which is shared by several server
+// instances.
+type PubSub interface {
+	Publish(message string) error
+	// Subscribe calls handler for every published message, including
+	// the instance's own ones.
+	Subscribe(handler func(message string)) error
+}
+
+// PubSubBroker is a Broker for running several server instances. Events
+// are published to all instances, each of which renders and delivers them
+// to its own listeners. Event ids are assigned by each instance, so
+// resuming only works when reconnecting to the same instance.
+type PubSubBroker struct {
+	*MemoryBroker
+	pubsub PubSub
+}
+
+func NewPubSubBroker(pubsub PubSub) (*PubSubBroker, error) {
+	b := &PubSubBroker{
+		MemoryBroker: NewMemoryBroker(),
+		pubsub:       pubsub,
+	}
+	err := pubsub.Subscribe(b.receive)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newBroker creates the Broker described by brokerURL.
+func newBroker(brokerURL string) (Broker, error) {
+	if brokerURL == "" {
+		return NewMemoryBroker(), nil
+	}
+	u, err := url.Parse(brokerURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "loopback":
+		return NewPubSubBroker(NewLoopbackPubSub())
+	case "redis":
+		return NewPubSubBroker(NewRedisPubSub(u))
+	}
+	return nil, fmt.Errorf("unsupported broker scheme %q", u.Scheme)
+}
+
+func (b *PubSubBroker) Send(id uint64, message string) {
+	b.SendData(id, message, "")
+}
+
+// SendData publishes the payload along with the event, as "<id> <name>"
+// or "<id> <name> <data>".
+func (b *PubSubBroker) SendData(id uint64, message string, data string) {
+	published := fmt.Sprintf("%d %s", id, message)
+	if data != "" {
+		published += " " + data
+	}
+	err := b.pubsub.Publish(published)
+	if err != nil {
+		log.Printf("failed to publish event, delivering locally only: %s", err)
+		b.MemoryBroker.SendData(id, message, data)
+	}
+}
+
+func (b *PubSubBroker) receive(message string) {
+	parts := strings.SplitN(message, " ", 3)
+	if len(parts) < 2 {
+		log.Printf("ignoring malformed broker message %q", message)
+		return
+	}
+	id, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		log.Printf("ignoring malformed broker message %q", message)
+		return
+	}
+	e := brokerEvent{name: parts[1]}
+	if len(parts) == 3 {
+		e.data = parts[2]
+	}
+	// Rendering queries the database, so it must not hold up the
+	// subscription, which is shared by all games:
+	b.MemoryBroker.deliver(id, e)
+}
+
+// LoopbackPubSub is an in-process stand-in for a shared transport. Brokers
+// sharing one LoopbackPubSub behave like separate server instances.
+type LoopbackPubSub struct {
+	mu       sync.Mutex
+	handlers []func(string)
+}
+
+func NewLoopbackPubSub() *LoopbackPubSub {
+	return &LoopbackPubSub{}
+}
+
+func (p *LoopbackPubSub) Publish(message string) error {
+	p.mu.Lock()
+	handlers := append([]func(string){}, p.handlers...)
+	p.mu.Unlock()
+	for _, handler := range handlers {
+		handler(message)
+	}
+	return nil
+}
+
+func (p *LoopbackPubSub) Subscribe(handler func(message string)) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers = append(p.handlers, handler)
+	return nil
+}
+
+// RedisPubSub uses the publish/subscribe feature of Redis or any server
+// speaking its protocol, e.g. redis://:password@host:6379/channel
+type RedisPubSub struct {
+	addr     string
+	password string
+	channel  string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	reader  *bufio.Reader
+	handler func(string)
+}
+
+func NewRedisPubSub(u *url.URL) *RedisPubSub {
+	p := &RedisPubSub{
+		addr:    u.Host,
+		channel: strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.Port() == "" {
+		p.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		p.password, _ = u.User.Password()
+	}
+	if p.channel == "" {
+		p.channel = PUBSUB_DEFAULT_CHANNEL
+	}
+	return p
+}
+
+func (p *RedisPubSub) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.DialTimeout("tcp", p.addr, PUBSUB_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	if p.password != "" {
+		_, err = redisCommand(conn, reader, "AUTH", p.password)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, reader, nil
+}
+
+func (p *RedisPubSub) Publish(message string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var err error
+	// Retry once, the connection may have been closed in the meantime:
+	for attempt := 0; attempt < 2; attempt++ {
+		if p.conn == nil {
+			p.conn, p.reader, err = p.dial()
+			if err != nil {
+				return err
+			}
+		}
+		_, err = redisCommand(p.conn, p.reader, "PUBLISH", p.channel, message)
+		if err == nil {
+			return nil
+		}
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+// Subscribe connects right away, so that configuration problems show
+// up on startup. Afterwards, the connection is re-established as needed.
+func (p *RedisPubSub) Subscribe(handler func(message string)) error {
+	conn, reader, err := p.subscribe()
+	if err != nil {
+		return err
+	}
+	p.handler = handler
+	go func() {
+		for {
+			err := p.receive(reader)
+			conn.Close()
+			log.Printf("lost pubsub subscription: %s", err)
+			for {
+				time.Sleep(PUBSUB_RECONNECT_DELAY)
+				conn, reader, err = p.subscribe()
+				if err == nil {
+					break
+				}
+				log.Printf("failed to resubscribe: %s", err)
+			}
+		}
+	}()
+	return nil
+}
+
+func (p *RedisPubSub) subscribe() (net.Conn, *bufio.Reader, error) {
+	conn, reader, err := p.dial()
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = redisCommand(conn, reader, "SUBSCRIBE", p.channel)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+func (p *RedisPubSub) receive(reader *bufio.Reader) error {
+	for {
+		v, err := redisRead(reader)
+		if err != nil {
+			return err
+		}
+		msg, ok := v.([]interface{})
+		if !ok || len(msg) != 3 || msg[0] != "message" {
+			continue
+		}
+		if payload, ok := msg[2].(string); ok {
+			p.handler(payload)
+		}
+	}
+}
+
+// redisCommand sends a command and reads its reply.
+func redisCommand(w io.Writer, r *bufio.Reader, args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	if err != nil {
+		return nil, err
+	}
+	return redisRead(r)
+}
+
+// redisRead parses a single reply. Strings are returned as string,
+// integers as int64 and arrays as []interface{}.
+func redisRead(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			values[i], err = redisRead(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("unexpected reply %q", line)
+}
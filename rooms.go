@@ -0,0 +1,129 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Room is a persistent identity for a group which plays several games.
+// The cards dealt in its games are avoided in its later games.
+type Room struct {
+	ID          uint64
+	Token       string `gorm:"unique; not null"`
+	SeenResetAt *time.Time
+	CreatedAt   time.Time
+}
+
+func createRoom(c *gin.Context) {
+	room := Room{Token: generateToken()}
+	err := db.Create(&room).Error
+	if err != nil {
+		log.Printf("failed to create room: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(201, gin.H{"room_token": room.Token})
+}
+
+func getVerifiedRoom(c *gin.Context) (Room, error) {
+	var room Room
+	err := db.First(&room, "token = ?", c.Param("room_token")).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(404, gin.H{"error": "invalid room_token"})
+		return room, err4xx
+	}
+	return room, err
+}
+
+func getRoom(c *gin.Context) {
+	room, err := getVerifiedRoom(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified room: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+
+	var numSeen, numCards uint64
+	q := db.Table("words")
+	q = q.Select("COUNT(DISTINCT words.card_id)")
+	q = q.Joins("JOIN games ON games.id = words.game_id")
+	q = q.Where("games.room_id = ?", room.ID)
+	if room.SeenResetAt != nil {
+		q = q.Where("games.created_at >= ?", *room.SeenResetAt)
+	}
+	err = q.Row().Scan(&numSeen)
+	if err == nil {
+		err = db.Model(&Card{}).Count(&numCards).Error
+	}
+	if err != nil {
+		log.Printf("failed to count seen cards: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, gin.H{
+		"room_token":  room.Token,
+		"seen_cards":  numSeen,
+		"total_cards": numCards,
+	})
+}
+
+// resetRoomSeenCards lets the room's games use all cards again.
+func resetRoomSeenCards(c *gin.Context) {
+	room, err := getVerifiedRoom(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified room: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	err = db.Model(&room).UpdateColumn("seen_reset_at", time.Now()).Error
+	if err != nil {
+		log.Printf("failed to reset seen cards: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, nil)
+}
+
+// seenCards queries the cards which were dealt in other games of the
+// game's room or of its players' profiles since their last reset.
+func seenCards(tx *gorm.DB, game *Game) interface{} {
+	profiles := tx.Table("players").Select("profile_id").Where("game_id = ? AND profile_id IS NOT NULL", game.ID).SubQuery()
+	profileGames := tx.Table("players")
+	profileGames = profileGames.Select("players.game_id")
+	profileGames = profileGames.Joins("JOIN profiles ON profiles.id = players.profile_id")
+	profileGames = profileGames.Where("players.profile_id IN ?", profiles)
+	profileGames = profileGames.Where("profiles.seen_reset_at IS NULL OR games.created_at >= profiles.seen_reset_at")
+
+	q := tx.Table("words")
+	q = q.Select("words.card_id")
+	q = q.Joins("JOIN games ON games.id = words.game_id")
+	q = q.Where("words.game_id <> ?", game.ID)
+	if game.RoomID != nil {
+		q = q.Where("words.game_id IN ? OR (games.room_id = ? AND (SELECT seen_reset_at IS NULL OR games.created_at >= seen_reset_at FROM rooms WHERE id = ?))", profileGames.SubQuery(), *game.RoomID, *game.RoomID)
+	} else {
+		q = q.Where("words.game_id IN ?", profileGames.SubQuery())
+	}
+	return q.SubQuery()
+}
+
+// resetSeenCards forgets the cards seen by the game's room and players
+// before the game, which is done once there are too few unseen cards left.
+func resetSeenCards(tx *gorm.DB, game *Game) error {
+	now := game.CreatedAt
+	if game.RoomID != nil {
+		err := tx.Model(&Room{}).Where("id = ?", *game.RoomID).UpdateColumn("seen_reset_at", now).Error
+		if err != nil {
+			return err
+		}
+	}
+	profiles := tx.Table("players").Select("profile_id").Where("game_id = ? AND profile_id IS NOT NULL", game.ID).SubQuery()
+	return tx.Model(&Profile{}).Where("id IN ?", profiles).UpdateColumn("seen_reset_at", now).Error
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"math/rand"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// CARD_SELECTION_* name the available CardSelectors.
+	CARD_SELECTION_LEAST_USED = "least-used"
+	CARD_SELECTION_RANDOM     = "random"
+	CARD_SELECTION_CATEGORY   = "category"
+	CARD_SELECTION_DIFFICULTY = "difficulty"
+	CARD_SELECTION_AVOID_SEEN = "avoid-seen"
+)
+
+// CardSelector picks the cards which are dealt in a round.
+type CardSelector interface {
+	// SelectCards returns up to n cards which haven't been used in the
+	// game yet.
+	SelectCards(tx *gorm.DB, game *Game, n int) ([]Card, error)
+}
+
+var cardSelectors = map[string]CardSelector{
+	CARD_SELECTION_LEAST_USED: leastUsedSelector{},
+	CARD_SELECTION_RANDOM:     randomSelector{},
+	CARD_SELECTION_CATEGORY:   categorySelector{},
+	CARD_SELECTION_DIFFICULTY: difficultySelector{},
+	CARD_SELECTION_AVOID_SEEN: avoidSeenSelector{},
+}
+
+// getCardSelector returns the game's selector, falling back to the
+// server's default.
+func getCardSelector(game *Game) CardSelector {
+	if selector, ok := cardSelectors[game.CardSelection]; ok {
+		return selector
+	}
+	return cardSelectors[cardSelection]
+}
+
+// unusedCards queries the cards which haven't been dealt in the game.
+func unusedCards(tx *gorm.DB, game *Game) *gorm.DB {
+	used := tx.Table("words").Select("card_id").Where("game_id = ?", game.ID).SubQuery()
+	return tx.Model(&Card{}).Where("cards.id NOT IN ?", used)
+}
+
+// leastUsedSelector prefers the cards which have been dealt the least
+// across all games.
+type leastUsedSelector struct{}
+
+func (leastUsedSelector) SelectCards(tx *gorm.DB, game *Game, n int) ([]Card, error) {
+	var cards []Card
+	q := unusedCards(tx, game)
+	q = q.Order("times_dealt")
+	q = q.Order(gorm.Expr("random()"))
+	err := q.Limit(n).Find(&cards).Error
+	return cards, err
+}
+
+type randomSelector struct{}
+
+func (randomSelector) SelectCards(tx *gorm.DB, game *Game, n int) ([]Card, error) {
+	var cards []Card
+	err := unusedCards(tx, game).Order(gorm.Expr("random()")).Limit(n).Find(&cards).Error
+	return cards, err
+}
+
+// categorySelector deals cards of as many different categories as
+// possible within a round.
+type categorySelector struct{}
+
+func (categorySelector) SelectCards(tx *gorm.DB, game *Game, n int) ([]Card, error) {
+	var candidates []Card
+	q := unusedCards(tx, game)
+	q = q.Order("times_dealt")
+	q = q.Order(gorm.Expr("random()"))
+	err := q.Find(&candidates).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var categories []string
+	byCategory := make(map[string][]Card)
+	for _, card := range candidates {
+		if _, ok := byCategory[card.Category]; !ok {
+			categories = append(categories, card.Category)
+		}
+		byCategory[card.Category] = append(byCategory[card.Category], card)
+	}
+	rand.Shuffle(len(categories), func(i, j int) {
+		categories[i], categories[j] = categories[j], categories[i]
+	})
+
+	// Take one card of every category in turn:
+	cards := make([]Card, 0, n)
+	for len(cards) < n && len(cards) < len(candidates) {
+		for _, category := range categories {
+			if len(cards) == n {
+				break
+			}
+			if len(byCategory[category]) == 0 {
+				continue
+			}
+			cards = append(cards, byCategory[category][0])
+			byCategory[category] = byCategory[category][1:]
+		}
+	}
+	return cards, nil
+}
+
+// difficultySelector prefers cards close to the game's CardDifficulty.
+type difficultySelector struct{}
+
+func (difficultySelector) SelectCards(tx *gorm.DB, game *Game, n int) ([]Card, error) {
+	if game.CardDifficulty == nil {
+		return leastUsedSelector{}.SelectCards(tx, game, n)
+	}
+	var cards []Card
+	q := unusedCards(tx, game)
+	q = q.Order(gorm.Expr("ROUND(ABS(cards.difficulty - ?) * 10)", *game.CardDifficulty))
+	q = q.Order("times_dealt")
+	q = q.Order(gorm.Expr("random()"))
+	err := q.Limit(n).Find(&cards).Error
+	return cards, err
+}
+
+// avoidSeenSelector avoids the cards which the game's room or players
+// have seen in their earlier games, see seenCards. Once too few unseen
+// cards are left, they start over with all cards.
+type avoidSeenSelector struct{}
+
+func (avoidSeenSelector) SelectCards(tx *gorm.DB, game *Game, n int) ([]Card, error) {
+	var cards []Card
+	q := unusedCards(tx, game)
+	q = q.Where("cards.id NOT IN ?", seenCards(tx, game))
+	q = q.Order("times_dealt")
+	q = q.Order(gorm.Expr("random()"))
+	err := q.Limit(n).Find(&cards).Error
+	if err != nil || len(cards) == n {
+		return cards, err
+	}
+
+	// Everything has been seen, start over and repeat the least used
+	// cards:
+	err = resetSeenCards(tx, game)
+	if err != nil {
+		return nil, err
+	}
+	var more []Card
+	q = unusedCards(tx, game)
+	if len(cards) > 0 {
+		ids := make([]uint64, len(cards))
+		for i, card := range cards {
+			ids[i] = card.ID
+		}
+		q = q.Where("cards.id NOT IN (?)", ids)
+	}
+	q = q.Order("times_dealt")
+	q = q.Order(gorm.Expr("random()"))
+	err = q.Limit(n - len(cards)).Find(&more).Error
+	return append(cards, more...), err
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jinzhu/gorm"
+)
+
+const benchmarkNumCards = 500
+
+// setupSelectionBenchmark creates an in-memory database with numWords
+// words dealt in earlier games.
+func setupSelectionBenchmark(b *testing.B, numWords int) (*gorm.DB, *Game) {
+	tdb, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		b.Fatal(err)
+	}
+	// Every connection would get its own in-memory database:
+	tdb.DB().SetMaxOpenConns(1)
+	tdb.AutoMigrate(&Game{}, &Player{}, &Card{}, &Word{}, &Guess{}, &Profile{}, &Room{})
+
+	err = tdb.Transaction(func(tx *gorm.DB) error {
+		for x := 0; x < benchmarkNumCards; x++ {
+			err := tx.Create(&Card{Text: fmt.Sprintf("card %d", x), Category: fmt.Sprintf("category %d", x%7)}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for x := 0; x < numWords; x++ {
+			err := tx.Exec("INSERT INTO words (game_id, round, card_id) VALUES (?, 1, ?)", x/6+1000, x%benchmarkNumCards+1).Error
+			if err != nil {
+				return err
+			}
+		}
+		return updateCardStats(tx)
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	game := &Game{Token: "benchmark", Round: 1}
+	err = tdb.Create(game).Error
+	if err != nil {
+		b.Fatal(err)
+	}
+	return tdb, game
+}
+
+// legacyLeastUsedCard is the former card selection, which counted the
+// usage of all cards across all games for every dealt card.
+func legacyLeastUsedCard(tx *gorm.DB, game *Game) (Card, error) {
+	var card Card
+	q := tx.Table("cards")
+	q = q.Select("cards.*, COUNT(words_usage.card_id) as usage_count")
+	q = q.Joins("LEFT JOIN words AS words_usage ON words_usage.card_id = cards.id")
+	q = q.Joins("LEFT JOIN words ON words.card_id = cards.id AND words.game_id = ?", game.ID)
+	q = q.Group("cards.id")
+	q = q.Where("words.card_id IS NULL")
+	q = q.Order(gorm.Expr("random()"))
+	q = q.Order("usage_count")
+	err := q.Limit(1).First(&card).Error
+	return card, err
+}
+
+func BenchmarkCardSelection(b *testing.B) {
+	for _, numWords := range []int{1000, 10000, 100000} {
+		tdb, game := setupSelectionBenchmark(b, numWords)
+
+		b.Run(fmt.Sprintf("legacy/words=%d", numWords), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				for x := 0; x < 6; x++ {
+					_, err := legacyLeastUsedCard(tdb, game)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+		for _, name := range []string{
+			CARD_SELECTION_LEAST_USED,
+			CARD_SELECTION_RANDOM,
+			CARD_SELECTION_CATEGORY,
+			CARD_SELECTION_DIFFICULTY,
+			CARD_SELECTION_AVOID_SEEN,
+		} {
+			selector := cardSelectors[name]
+			b.Run(fmt.Sprintf("%s/words=%d", name, numWords), func(b *testing.B) {
+				for n := 0; n < b.N; n++ {
+					cards, err := selector.SelectCards(tdb, game, 6)
+					if err != nil {
+						b.Fatal(err)
+					}
+					if len(cards) != 6 {
+						b.Fatalf("got %d cards", len(cards))
+					}
+				}
+			})
+		}
+		tdb.Close()
+	}
+}
+
+func TestCategorySelectorBalancesCategories(t *testing.T) {
+	tdb, err := gorm.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tdb.Close()
+	tdb.DB().SetMaxOpenConns(1)
+	tdb.AutoMigrate(&Game{}, &Player{}, &Card{}, &Word{}, &Profile{}, &Room{})
+	for x := 0; x < 30; x++ {
+		tdb.Create(&Card{Text: fmt.Sprintf("card %d", x), Category: fmt.Sprintf("category %d", x%3)})
+	}
+	game := &Game{Token: "test", Round: 1}
+	tdb.Create(game)
+
+	cards, err := categorySelector{}.SelectCards(tdb, game, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	perCategory := make(map[string]int)
+	for _, card := range cards {
+		perCategory[card.Category]++
+	}
+	for category, num := range perCategory {
+		if num != 2 {
+			t.Errorf("got %d cards of %s instead of 2", num, category)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	SESSION_COOKIE_NAME    = "woadkwizz_session"
+	SESSION_COOKIE_MAX_AGE = 60 * 60 * 24 * 30
+)
+
+var sessionKey []byte
+
+// setupSessions prepares the key used for signing session cookies.
+// Without an explicitly configured secret, a random one is generated,
+// which means that sessions do not survive server restarts.
+func setupSessions() {
+	if !sessionCookies {
+		return
+	}
+	if sessionSecret != "" {
+		sessionKey = []byte(sessionSecret)
+		return
+	}
+	sessionKey = make([]byte, 32)
+	if _, err := rand.Read(sessionKey); err != nil {
+		panic(fmt.Sprintf("rand.Read failed: %s", err))
+	}
+	log.Printf("no -sessionSecret given, sessions will be lost on restart")
+}
+
+func signSession(gameToken, playerToken string) string {
+	mac := hmac.New(sha256.New, sessionKey)
+	mac.Write([]byte(gameToken + "/" + playerToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setSessionCookie hands the player credential to the browser as an
+// HttpOnly cookie which is only sent along with API calls for this game.
+func setSessionCookie(c *gin.Context, game Game, player Player) {
+	if !sessionCookies {
+		return
+	}
+	value := player.Token + "." + signSession(game.Token, player.Token)
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteStrictMode)
+	c.SetCookie(SESSION_COOKIE_NAME, value, SESSION_COOKIE_MAX_AGE, "/api/games/"+game.Token, "", secure, true)
+}
+
+// getSessionPlayerToken returns the player token from a validly signed
+// session cookie for the given game.
+func getSessionPlayerToken(c *gin.Context, gameToken string) (string, bool) {
+	if !sessionCookies {
+		return "", false
+	}
+	value, err := c.Cookie(SESSION_COOKIE_NAME)
+	if err != nil {
+		return "", false
+	}
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	expected := signSession(gameToken, parts[0])
+	if !hmac.Equal([]byte(parts[1]), []byte(expected)) {
+		return "", false
+	}
+	return parts[0], true
+}
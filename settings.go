@@ -0,0 +1,322 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// SETTINGS_MAX_* limit the letter counts. Tiles may only be dealt a
+	// few times each, so every tile set has to provide enough different
+	// ones, see readTileSet.
+	SETTINGS_MAX_VOCALS     = 10
+	SETTINGS_MAX_CONSONANTS = 12
+	SETTINGS_MAX_SPACES     = 4
+	SETTINGS_MAX_ROUNDS     = 100
+	SETTINGS_MAX_DECOYS     = 10
+	SETTINGS_MAX_MULLIGANS  = 5
+	SETTINGS_MAX_JOKERS     = 3
+
+	// DECOY_MODE_* tell how GameSettings.Decoys is meant: as the number of
+	// additional cards or as the number of cards on the table.
+	DECOY_MODE_FIXED = "fixed"
+	DECOY_MODE_TOTAL = "total"
+	DEFAULT_DECOYS   = 6
+
+	// MULLIGAN_SCOPE_* tell whether GameSettings.Mulligans is the number of
+	// redraws per round or per game.
+	MULLIGAN_SCOPE_ROUND = "round"
+	MULLIGAN_SCOPE_GAME  = "game"
+	DEFAULT_MULLIGANS    = 1
+)
+
+// GameSettings are the rules of a game. They are chosen when starting
+// the game and can be changed by the host until the first round starts.
+type GameSettings struct {
+	Public   bool   `json:"public" gorm:"not null; default:0"`
+	Language string `json:"language" gorm:"not null; default:'de'"`
+	// MinPlayers is the number of players needed for starting a round.
+	MinPlayers uint `json:"min_players" gorm:"not null; default:3"`
+	MaxPlayers uint `json:"max_players" gorm:"not null; default:0"` // 0 means unlimited
+	// MaxRounds ends the game after that many rounds, 0 means endless.
+	MaxRounds     int64 `json:"max_rounds" gorm:"not null; default:0"`
+	NumVocals     int   `json:"num_vocals" gorm:"not null; default:4"`
+	NumConsonants int   `json:"num_consonants" gorm:"not null; default:6"`
+	// DecoyMode tells how Decoys is meant, see DECOY_MODE_*.
+	DecoyMode string `json:"decoy_mode" gorm:"not null; default:'total'"`
+	// NumJokers is the number of tiles which can stand for any letter.
+	NumJokers int `json:"num_jokers" gorm:"not null; default:0"`
+	// JokerPenalty makes every joker cost the word one point.
+	JokerPenalty bool `json:"joker_penalty" gorm:"not null; default:0"`
+	// BanDictionaryWords rejects real words, as far as the language's
+	// dictionary knows them.
+	BanDictionaryWords bool `json:"ban_dictionary_words" gorm:"not null; default:0"`
+	// MulliganScope tells how Mulligans is meant, see MULLIGAN_SCOPE_*.
+	MulliganScope string `json:"mulligan_scope" gorm:"not null; default:'round'"`
+	// The defaults are part of the type, as gorm would replace zero
+	// values by the defaults from the tags when creating the game:
+	NumSpaces int `json:"num_spaces" gorm:"type:integer not null default 2"`
+	Decoys    int `json:"decoys" gorm:"type:integer not null default 6"`
+	// Mulligans is the number of times a player may redraw their letters.
+	Mulligans int `json:"mulligans" gorm:"type:integer not null default 1"`
+	// MinWordLength is the number of letters a word needs at least.
+	MinWordLength int `json:"min_word_length" gorm:"type:integer not null default 2"`
+	// RequireVowel rejects words without any vowel.
+	RequireVowel bool `json:"require_vowel" gorm:"type:boolean not null default 1"`
+	// BanCardWords rejects words which are taken from the card's text.
+	BanCardWords bool `json:"ban_card_words" gorm:"type:boolean not null default 1"`
+	// CardDifficulty is the preferred Card.Difficulty, if any.
+	CardDifficulty *float64 `json:"card_difficulty"`
+	// CardSelection names the CardSelector, empty for the default.
+	CardSelection string `json:"card_selection"`
+}
+
+func defaultGameSettings() GameSettings {
+	return GameSettings{
+		Language:      GAME_LANGUAGE_DEFAULT,
+		MinPlayers:    GAME_MIN_PLAYERS,
+		NumVocals:     WORD_NUM_VOCALS,
+		NumConsonants: WORD_NUM_CONSONANTS,
+		NumSpaces:     WORD_NUM_SPACES,
+		DecoyMode:     DECOY_MODE_TOTAL,
+		Decoys:        DEFAULT_DECOYS,
+		MulliganScope: MULLIGAN_SCOPE_ROUND,
+		Mulligans:     DEFAULT_MULLIGANS,
+		MinWordLength: WORD_MIN_LENGTH,
+		RequireVowel:  true,
+		BanCardWords:  true,
+	}
+}
+
+// NumDecoys is the number of cards without a player which are dealt
+// along with the players' cards. Filling up to a total keeps at least one
+// decoy, so that the last card can't be worked out by elimination.
+func (s GameSettings) NumDecoys(numPlayers int) int {
+	if s.DecoyMode == DECOY_MODE_FIXED {
+		return s.Decoys
+	}
+	if numPlayers >= s.Decoys {
+		return 1
+	}
+	return s.Decoys - numPlayers
+}
+
+// validate checks the settings and fills in derived defaults. The error
+// is meant for the client.
+func (s *GameSettings) validate() error {
+	if s.CardDifficulty != nil && (*s.CardDifficulty < 0 || *s.CardDifficulty > 1) {
+		return newActionError(400, "invalid card_difficulty")
+	}
+	if _, ok := cardSelectors[s.CardSelection]; s.CardSelection != "" && !ok {
+		return newActionError(400, "invalid card_selection")
+	}
+	if s.CardSelection == "" && s.CardDifficulty != nil {
+		s.CardSelection = CARD_SELECTION_DIFFICULTY
+	}
+	if s.Language == "" {
+		s.Language = GAME_LANGUAGE_DEFAULT
+	}
+	if !gameLanguages[s.Language] {
+		return newActionError(400, "invalid language")
+	}
+	if s.MinPlayers < GAME_MIN_PLAYERS || s.MinPlayers > GAME_MAX_PLAYERS {
+		return newActionError(400, "invalid min_players")
+	}
+	if s.MaxPlayers != 0 && (s.MaxPlayers < s.MinPlayers || s.MaxPlayers > GAME_MAX_PLAYERS) {
+		return newActionError(400, "invalid max_players")
+	}
+	if s.MaxRounds < 0 || s.MaxRounds > SETTINGS_MAX_ROUNDS {
+		return newActionError(400, "invalid max_rounds")
+	}
+	if s.NumVocals < 1 || s.NumVocals > SETTINGS_MAX_VOCALS {
+		return newActionError(400, "invalid num_vocals")
+	}
+	if s.NumConsonants < 1 || s.NumConsonants > SETTINGS_MAX_CONSONANTS {
+		return newActionError(400, "invalid num_consonants")
+	}
+	if s.NumSpaces < 0 || s.NumSpaces > SETTINGS_MAX_SPACES {
+		return newActionError(400, "invalid num_spaces")
+	}
+	if s.NumJokers < 0 || s.NumJokers > SETTINGS_MAX_JOKERS {
+		return newActionError(400, "invalid num_jokers")
+	}
+	switch s.DecoyMode {
+	case DECOY_MODE_FIXED:
+		if s.Decoys < 0 || s.Decoys > SETTINGS_MAX_DECOYS {
+			return newActionError(400, "invalid decoys")
+		}
+	case DECOY_MODE_TOTAL:
+		if s.Decoys < 1 || s.Decoys > GAME_MAX_PLAYERS+SETTINGS_MAX_DECOYS {
+			return newActionError(400, "invalid decoys")
+		}
+	default:
+		return newActionError(400, "invalid decoy_mode")
+	}
+	if s.MulliganScope != MULLIGAN_SCOPE_ROUND && s.MulliganScope != MULLIGAN_SCOPE_GAME {
+		return newActionError(400, "invalid mulligan_scope")
+	}
+	if s.Mulligans < 0 || s.Mulligans > SETTINGS_MAX_MULLIGANS {
+		return newActionError(400, "invalid mulligans")
+	}
+	if s.MinWordLength < 1 || s.MinWordLength > s.NumVocals+s.NumConsonants {
+		return newActionError(400, "invalid min_word_length")
+	}
+	if s.BanDictionaryWords && dictionaries[s.Language] == nil {
+		return newActionError(400, "invalid ban_dictionary_words")
+	}
+	return nil
+}
+
+// columns lists the settings for updating them, as gorm skips zero
+// values when updating from a struct.
+func (s GameSettings) columns() map[string]interface{} {
+	return map[string]interface{}{
+		"public":               s.Public,
+		"language":             s.Language,
+		"min_players":          s.MinPlayers,
+		"max_players":          s.MaxPlayers,
+		"max_rounds":           s.MaxRounds,
+		"num_vocals":           s.NumVocals,
+		"num_consonants":       s.NumConsonants,
+		"num_spaces":           s.NumSpaces,
+		"num_jokers":           s.NumJokers,
+		"joker_penalty":        s.JokerPenalty,
+		"decoy_mode":           s.DecoyMode,
+		"decoys":               s.Decoys,
+		"mulligan_scope":       s.MulliganScope,
+		"mulligans":            s.Mulligans,
+		"min_word_length":      s.MinWordLength,
+		"require_vowel":        s.RequireVowel,
+		"ban_card_words":       s.BanCardWords,
+		"ban_dictionary_words": s.BanDictionaryWords,
+		"card_difficulty":      s.CardDifficulty,
+		"card_selection":       s.CardSelection,
+	}
+}
+
+type jsonSettings struct {
+	GameSettings
+	Host string `json:"host"`
+}
+
+func getSettingsJson(game Game) (jsonSettings, error) {
+	settings := jsonSettings{GameSettings: game.GameSettings}
+	host, err := game.Host(db)
+	if err != nil {
+		return settings, err
+	}
+	settings.Host = host.Name
+	return settings, nil
+}
+
+func getSettings(c *gin.Context) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	settings, err := getSettingsJson(game)
+	if err != nil {
+		log.Printf("getSettingsJson failed: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, settings)
+}
+
+func updateSettings(c *gin.Context) {
+	player, err := getVerifiedPlayer(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("getVerifiedPlayer failed: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+	settings, err := changeSettings(c, player)
+	if err != nil {
+		respondActionError(c, err, "updateSettings")
+		return
+	}
+	c.JSON(200, settings)
+}
+
+// changeSettings applies the fields of the request body to the game's
+// settings.
+func changeSettings(c *gin.Context, player Player) (jsonSettings, error) {
+	unlock, err := lockPlayerGame(&player)
+	if err != nil {
+		return jsonSettings{}, err
+	}
+	defer unlock()
+
+	game := player.Game
+	host, err := game.Host(db)
+	if err != nil {
+		return jsonSettings{}, fmt.Errorf("failed to find host: %s", err)
+	}
+	if host.ID != player.ID {
+		return jsonSettings{}, newActionError(403, "only the host can change settings")
+	}
+	if game.Round != 1 || game.Phase != GAME_PHASE_WAIT_FOR_READY {
+		return jsonSettings{}, newActionError(403, "cannot change settings after game start")
+	}
+
+	settings := game.GameSettings
+	// Fields missing from the body keep their value:
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		return jsonSettings{}, newActionError(400, "invalid settings")
+	}
+	err = settings.validate()
+	if err != nil {
+		return jsonSettings{}, err
+	}
+	var numPlayers uint64
+	err = db.Model(&Player{}).Where("game_id = ?", game.ID).Count(&numPlayers).Error
+	if err != nil {
+		return jsonSettings{}, fmt.Errorf("failed to count players: %s", err)
+	}
+	if settings.MaxPlayers != 0 && numPlayers > uint64(settings.MaxPlayers) {
+		return jsonSettings{}, newActionError(400, "invalid max_players")
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Game{}).Where("id = ?", game.ID).UpdateColumns(settings.columns()).Error
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, game, &player.ID, GAME_EVENT_SETTINGS_CHANGED, settings)
+	})
+	if err != nil {
+		return jsonSettings{}, fmt.Errorf("failed to save settings: %s", err)
+	}
+
+	broker.Send(game.ID, "settings")
+	if game.Public || settings.Public {
+		// The game has been listed, changed or unlisted:
+		broker.Send(LOBBY_BROKER_ID, "lobby")
+	}
+	return jsonSettings{GameSettings: settings, Host: host.Name}, nil
+}
+
+func renderSettingsEvent(gameID uint64, playerID uint64) (string, bool) {
+	var game Game
+	err := db.First(&game, gameID).Error
+	if err != nil {
+		log.Printf("failed to find game for settings event: %s", err)
+		return "", false
+	}
+	settings, err := getSettingsJson(game)
+	if err != nil {
+		log.Printf("getSettingsJson failed for settings event: %s", err)
+		return "", false
+	}
+	return renderEventJson(settings)
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonPublicBoard is the board as seen by spectators. Unlike jsonBoard it
+// has no player's own view, so cards are only linked to players once
+// they have been revealed.
+type jsonPublicBoard struct {
+	Version         int64               `json:"version"`
+	Players         []jsonPlayer        `json:"players"`
+	Round           int64               `json:"round"`
+	Phase           string              `json:"phase"`
+	Cards           []jsonCard          `json:"cards"`
+	CurrentlyScored jsonCurrentlyScored `json:"currently_scored"`
+	ScoreboardOrder []uint64            `json:"scoreboard_order"`
+}
+
+func getPublicBoard(c *gin.Context) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+
+	board, err := getPublicBoardJson(game)
+	if err != nil {
+		log.Printf("getPublicBoardJson failed: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, board)
+}
+
+func getPublicBoardJson(game Game) (jsonPublicBoard, error) {
+	board := jsonPublicBoard{
+		Version: game.Version,
+		Round:   game.Round,
+		Phase:   game.Phase,
+		Cards:   make([]jsonCard, 0),
+	}
+
+	var err error
+	board.ScoreboardOrder, board.Players, err = getBoardPlayers(game, 0)
+	if err != nil {
+		return board, err
+	}
+
+	// Between rounds, the cards of the last round stay on the table:
+	var words []Word
+	q := db.Preload("Card")
+	q = q.Where("game_id = ?", game.ID)
+	q = q.Where("round = (SELECT MAX(round) FROM words WHERE game_id = ?)", game.ID)
+	q = q.Order("id")
+	err = q.Find(&words).Error
+	if err != nil {
+		return board, err
+	}
+	for _, word := range words {
+		card := jsonCard{
+			ID:   word.CardID,
+			Text: word.Card.Text,
+		}
+		var ownerID uint64
+		if word.PlayerID != nil {
+			ownerID = *word.PlayerID
+		}
+		if isCardOwnerVisible(getViewerRole(0, ownerID), word.IsScored) {
+			card.PlayerID = word.PlayerID
+		}
+		board.Cards = append(board.Cards, card)
+	}
+
+	if board.Phase == GAME_PHASE_SCORE {
+		board.CurrentlyScored, err = getCurrentlyScoredJson(game)
+		if err != nil {
+			return board, err
+		}
+	}
+	return board, nil
+}
+
+func renderPublicBoardEvent(gameID uint64, playerID uint64) (string, bool) {
+	if playerID != 0 {
+		// Players get their own board instead.
+		return "", false
+	}
+	var game Game
+	err := db.First(&game, gameID).Error
+	if err != nil {
+		log.Printf("failed to find game for public_board event: %s", err)
+		return "", false
+	}
+	board, err := getPublicBoardJson(game)
+	if err != nil {
+		log.Printf("getPublicBoardJson failed for public_board event: %s", err)
+		return "", false
+	}
+	return renderEventJson(board)
+}
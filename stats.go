@@ -0,0 +1,295 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/jinzhu/gorm"
+)
+
+const LEADERBOARD_SIZE = 50
+
+type jsonProfileStats struct {
+	ID             uint64  `json:"id"`
+	Name           string  `json:"name"`
+	GamesPlayed    uint64  `json:"games_played"`
+	GamesWon       uint64  `json:"games_won"`
+	ScoreTotal     uint64  `json:"score_total"`
+	GuessesTotal   uint64  `json:"guesses_total"`
+	GuessesCorrect uint64  `json:"guesses_correct"`
+	GuessAccuracy  float64 `json:"guess_accuracy"`
+	// WordsGuessed counts the own words which were guessed at least once,
+	// TimesGuessed counts all correct guesses of them.
+	WordsTotal   uint64 `json:"words_total"`
+	WordsGuessed uint64 `json:"words_guessed"`
+	TimesGuessed uint64 `json:"times_guessed"`
+	// Mulligans counts how often letters have been redrawn.
+	Mulligans  uint64               `json:"mulligans"`
+	Categories []jsonCategoryPoints `json:"categories"`
+}
+
+type jsonCategoryPoints struct {
+	Category string `json:"category"`
+	Points   uint64 `json:"points"`
+}
+
+type jsonLeaderboard struct {
+	Leaderboard []jsonLeaderboardRow `json:"leaderboard"`
+}
+
+type jsonLeaderboardRow struct {
+	ID          uint64 `json:"id"`
+	Name        string `json:"name"`
+	GamesPlayed uint64 `json:"games_played"`
+	GamesWon    uint64 `json:"games_won"`
+	ScoreTotal  uint64 `json:"score_total"`
+}
+
+type profileResults struct {
+	GamesPlayed uint64
+	GamesWon    uint64
+	ScoreTotal  uint64
+}
+
+func getProfileStats(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("profile_id"), 10, 64)
+	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid profile_id"})
+		return
+	}
+	var profile Profile
+	err = db.First(&profile, id).Error
+	if err == gorm.ErrRecordNotFound {
+		c.JSON(404, gin.H{"error": "invalid profile_id"})
+		return
+	}
+	if err != nil {
+		log.Printf("failed to find profile: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+
+	stats, err := getProfileStatsJson(profile)
+	if err != nil {
+		log.Printf("getProfileStatsJson failed: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	c.JSON(200, stats)
+}
+
+func getProfileStatsJson(profile Profile) (jsonProfileStats, error) {
+	stats := jsonProfileStats{
+		ID:         profile.ID,
+		Name:       profile.Name,
+		Categories: make([]jsonCategoryPoints, 0),
+	}
+	seats := db.Table("players").Select("id").Where("profile_id = ?", profile.ID).SubQuery()
+
+	results, err := getProfileResults(profile.ID)
+	if err != nil {
+		return stats, err
+	}
+	if r, ok := results[profile.ID]; ok {
+		stats.GamesPlayed = r.GamesPlayed
+		stats.GamesWon = r.GamesWon
+		stats.ScoreTotal = r.ScoreTotal
+	}
+
+	var guesses struct {
+		Total   uint64
+		Correct uint64
+	}
+	q := db.Table("guesses")
+	q = q.Select("COUNT(guesses.id) AS total, COALESCE(SUM(guesses.card_id = words.card_id), 0) AS correct")
+	q = q.Joins("JOIN words ON words.id = guesses.word_id AND words.is_scored = 1")
+	q = q.Where("guesses.player_id IN ?", seats)
+	err = q.Scan(&guesses).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.GuessesTotal = guesses.Total
+	stats.GuessesCorrect = guesses.Correct
+	if guesses.Total != 0 {
+		stats.GuessAccuracy = float64(guesses.Correct) / float64(guesses.Total)
+	}
+
+	var words struct {
+		WordsTotal   uint64
+		WordsGuessed uint64
+		TimesGuessed uint64
+	}
+	q = db.Table("words")
+	q = q.Select("COUNT(DISTINCT words.id) AS words_total, COUNT(DISTINCT guesses.word_id) AS words_guessed, COUNT(guesses.id) AS times_guessed")
+	q = q.Joins("LEFT JOIN guesses ON guesses.word_id = words.id AND guesses.card_id = words.card_id")
+	q = q.Where("words.is_scored = 1")
+	q = q.Where("words.player_id IN ?", seats)
+	err = q.Scan(&words).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.WordsTotal = words.WordsTotal
+	stats.WordsGuessed = words.WordsGuessed
+	stats.TimesGuessed = words.TimesGuessed
+
+	var mulligans struct {
+		Mulligans uint64
+	}
+	q = db.Table("words")
+	q = q.Select("COALESCE(SUM(words.redraws), 0) AS mulligans")
+	q = q.Where("words.player_id IN ?", seats)
+	err = q.Scan(&mulligans).Error
+	if err != nil {
+		return stats, err
+	}
+	stats.Mulligans = mulligans.Mulligans
+
+	stats.Categories, err = getCategoryPoints(profile.ID)
+	if err != nil {
+		return stats, err
+	}
+	return stats, nil
+}
+
+// getCategoryPoints sums up the points a profile has made per card
+// category, be it by guessing words right or by own words being guessed.
+// The favorite categories come first.
+func getCategoryPoints(profileID uint64) ([]jsonCategoryPoints, error) {
+	seats := db.Table("players").Select("id").Where("profile_id = ?", profileID).SubQuery()
+	var guessed, own []jsonCategoryPoints
+	q := db.Table("guesses")
+	q = q.Select("cards.category AS category, COUNT(guesses.id) AS points")
+	q = q.Joins("JOIN words ON words.id = guesses.word_id AND words.is_scored = 1 AND guesses.card_id = words.card_id")
+	q = q.Joins("JOIN cards ON cards.id = words.card_id")
+	q = q.Where("guesses.player_id IN ?", seats)
+	q = q.Group("cards.category")
+	err := q.Scan(&guessed).Error
+	if err != nil {
+		return nil, err
+	}
+	// Own words score like in selectPlayerScores:
+	q = db.Table("words")
+	q = q.Select("cards.category AS category, SUM(MAX(0, (SELECT COUNT(*) FROM guesses WHERE guesses.word_id = words.id AND guesses.card_id = words.card_id) - words.joker_penalty)) AS points")
+	q = q.Joins("JOIN cards ON cards.id = words.card_id")
+	q = q.Where("words.is_scored = 1")
+	q = q.Where("words.player_id IN ?", seats)
+	q = q.Group("cards.category")
+	err = q.Scan(&own).Error
+	if err != nil {
+		return nil, err
+	}
+
+	points := make(map[string]uint64)
+	for _, row := range append(guessed, own...) {
+		if row.Category != "" {
+			points[row.Category] += row.Points
+		}
+	}
+	categories := make([]jsonCategoryPoints, 0, len(points))
+	for category, p := range points {
+		if p > 0 {
+			categories = append(categories, jsonCategoryPoints{Category: category, Points: p})
+		}
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if categories[i].Points != categories[j].Points {
+			return categories[i].Points > categories[j].Points
+		}
+		return categories[i].Category < categories[j].Category
+	})
+	return categories, nil
+}
+
+func getLeaderboard(c *gin.Context) {
+	results, err := getProfileResults()
+	if err != nil {
+		log.Printf("getProfileResults failed: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+	ids := make([]uint64, 0, len(results))
+	for id := range results {
+		ids = append(ids, id)
+	}
+	var profiles []Profile
+	err = db.Where("id IN (?)", ids).Find(&profiles).Error
+	if err != nil {
+		log.Printf("failed to find profiles: %s", err)
+		c.AbortWithStatus(500)
+		return
+	}
+
+	leaderboard := jsonLeaderboard{Leaderboard: make([]jsonLeaderboardRow, 0, len(profiles))}
+	for _, profile := range profiles {
+		r := results[profile.ID]
+		leaderboard.Leaderboard = append(leaderboard.Leaderboard, jsonLeaderboardRow{
+			ID:          profile.ID,
+			Name:        profile.Name,
+			GamesPlayed: r.GamesPlayed,
+			GamesWon:    r.GamesWon,
+			ScoreTotal:  r.ScoreTotal,
+		})
+	}
+	rows := leaderboard.Leaderboard
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].GamesWon != rows[j].GamesWon {
+			return rows[i].GamesWon > rows[j].GamesWon
+		}
+		if rows[i].ScoreTotal != rows[j].ScoreTotal {
+			return rows[i].ScoreTotal > rows[j].ScoreTotal
+		}
+		return rows[i].ID < rows[j].ID
+	})
+	if len(rows) > LEADERBOARD_SIZE {
+		leaderboard.Leaderboard = rows[:LEADERBOARD_SIZE]
+	}
+	c.JSON(200, leaderboard)
+}
+
+// getProfileResults sums up the games of the given profiles, or of all
+// profiles if none are given. Only games in which the player has finished
+// at least one round count. A game is won by the players with the highest
+// score, if any.
+func getProfileResults(profileIDs ...uint64) (map[uint64]*profileResults, error) {
+	results := make(map[uint64]*profileResults)
+	games := db.Table("players").Select("game_id").Where("profile_id IS NOT NULL")
+	if len(profileIDs) > 0 {
+		games = games.Where("profile_id IN (?)", profileIDs)
+	}
+	scores := selectPlayerScores().Where("players.game_id IN ?", games.SubQuery())
+
+	var rows []struct {
+		ProfileID   uint64
+		GamesPlayed uint64
+		GamesWon    uint64
+		ScoreTotal  uint64
+	}
+	q := db.Table("players")
+	q = q.Select("players.profile_id, COUNT(players.id) AS games_played, SUM(scores.score_total > 0 AND scores.score_total = scores.best) AS games_won, SUM(scores.score_total) AS score_total")
+	q = q.Joins("JOIN games ON games.id = players.game_id")
+	// Every game's best score tells its winners:
+	q = q.Joins("JOIN (SELECT player_id, score_total, MAX(score_total) OVER (PARTITION BY game_id) AS best FROM ?) AS scores ON scores.player_id = players.id", scores.SubQuery())
+	q = q.Where("players.profile_id IS NOT NULL")
+	q = q.Where("games.round > 1")
+	// Players who joined late must have played a finished round:
+	q = q.Where("players.queued = 0 AND players.first_round < games.round")
+	if len(profileIDs) > 0 {
+		q = q.Where("players.profile_id IN (?)", profileIDs)
+	}
+	q = q.Group("players.profile_id")
+	err := q.Scan(&rows).Error
+	if err != nil {
+		return results, err
+	}
+	for _, row := range rows {
+		results[row.ProfileID] = &profileResults{
+			GamesPlayed: row.GamesPlayed,
+			GamesWon:    row.GamesWon,
+			ScoreTotal:  row.ScoreTotal,
+		}
+	}
+	return results, nil
+}
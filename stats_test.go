@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// linkTestProfiles gives each player a profile of their own.
+func linkTestProfiles(t *testing.T, players []Player) []uint64 {
+	var ids []uint64
+	for _, player := range players {
+		profile := Profile{Name: fmt.Sprintf("Profile %d", player.ID), Token: generateToken()}
+		err := db.Create(&profile).Error
+		if err == nil {
+			err = db.Model(&player).UpdateColumn("profile_id", profile.ID).Error
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, profile.ID)
+	}
+	return ids
+}
+
+func TestProfileResults(t *testing.T) {
+	setupTestDB(t)
+	want := make(map[uint64]profileResults)
+	for x := 0; x < 2; x++ {
+		game, players := createTestGame(t)
+		profileIDs := linkTestProfiles(t, players)
+		game = playTestRound(t, game, players)
+		if x == 0 {
+			game = playTestRound(t, game, players)
+		} else {
+			// Everybody guesses right, which would make everybody win:
+			err := db.Where("player_id = ?", players[2].ID).Delete(&Guess{}).Error
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		_, scores, err := getScoreByPlayers(game.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var best uint64
+		for _, score := range scores {
+			if score.ScoreTotal > best {
+				best = score.ScoreTotal
+			}
+		}
+		for i, player := range players {
+			r := profileResults{GamesPlayed: 1, ScoreTotal: scores[player.ID].ScoreTotal}
+			if best != 0 && r.ScoreTotal == best {
+				r.GamesWon = 1
+			}
+			want[profileIDs[i]] = r
+		}
+	}
+	// A game which hasn't finished a round doesn't count:
+	_, players := createTestGame(t)
+	linkTestProfiles(t, players)
+
+	results, err := getProfileResults()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(want) {
+		t.Errorf("got results for %d profiles, want %d", len(results), len(want))
+	}
+	for id, r := range want {
+		if results[id] == nil || *results[id] != r {
+			t.Errorf("profile %d: got %+v, want %+v", id, results[id], r)
+		}
+	}
+
+	for id := range want {
+		results, err = getProfileResults(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 || *results[id] != want[id] {
+			t.Errorf("profile %d alone: got %v", id, results)
+		}
+	}
+}
+
+func TestCategoryPoints(t *testing.T) {
+	setupTestDB(t)
+	game, players := createTestGame(t)
+	profileIDs := linkTestProfiles(t, players)
+	playTestRound(t, game, players)
+
+	// The first player's card is about animals, all others about food:
+	for x, player := range players {
+		category := "food"
+		if x == 0 {
+			category = "animals"
+		}
+		words := db.Table("words").Select("card_id").Where("game_id = ? AND player_id = ?", game.ID, player.ID).SubQuery()
+		err := db.Table("cards").Where("id IN ?", words).UpdateColumn("category", category).Error
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only one player has guessed the first player's word:
+	err := db.Where("player_id = ?", players[2].ID).Delete(&Guess{}).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		profileID uint64
+		want      []jsonCategoryPoints
+	}{
+		// Both own guesses were food, the own word was guessed once:
+		{profileIDs[0], []jsonCategoryPoints{{"food", 2}, {"animals", 1}}},
+		// A guessed animal, a guessed food and the own food word guessed once:
+		{profileIDs[1], []jsonCategoryPoints{{"food", 2}, {"animals", 1}}},
+		// No guesses left, the own word was guessed by the other two:
+		{profileIDs[2], []jsonCategoryPoints{{"food", 2}}},
+	}
+	for _, test := range tests {
+		categories, err := getCategoryPoints(test.profileID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(categories, test.want) {
+			t.Errorf("profile %d: got %v, want %v", test.profileID, categories, test.want)
+		}
+	}
+}
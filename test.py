@@ -1,11 +1,109 @@
 #!/usr/bin/env python3
+import os
 import sys
+import json
 import time
+import base64
+import socket
 import string
+import struct
+import threading
 import unittest
+import urllib.parse
 import requests
 
 SERVER = 'http://127.0.0.1:3000'
+ADMIN_TOKEN = 'test-admin-token'
+SESSION_COOKIE = 'woadkwizz_session'
+# Most tests lay fixed tiles, which doesn't work with the word rules.
+# These are covered by the test_word_rules* tests:
+NO_WORD_RULES = {
+    'min_word_length': 1,
+    'require_vowel': False,
+    'ban_card_words': False,
+}
+
+
+class WebSocket:
+    """Just enough of a WebSocket client for talking JSON to the server."""
+
+    def __init__(self, path):
+        url = urllib.parse.urlparse(SERVER)
+        self.sock = socket.create_connection((url.hostname, url.port), timeout=5)
+        key = base64.b64encode(os.urandom(16)).decode()
+        self.sock.sendall((
+            'GET %s HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\n'
+            'Connection: Upgrade\r\nSec-WebSocket-Key: %s\r\n'
+            'Sec-WebSocket-Version: 13\r\n\r\n' % (path, url.netloc, key)
+        ).encode())
+        response = b''
+        while b'\r\n\r\n' not in response:
+            response += self.sock.recv(1)
+        self.status_code = int(response.split(b' ')[1])
+
+    def read(self, n):
+        data = b''
+        while len(data) < n:
+            chunk = self.sock.recv(n - len(data))
+            if not chunk:
+                raise EOFError('connection closed')
+            data += chunk
+        return data
+
+    def send_frame(self, opcode, payload):
+        header = bytes([0x80 | opcode])
+        if len(payload) < 126:
+            header += bytes([0x80 | len(payload)])
+        else:
+            header += bytes([0x80 | 126]) + struct.pack('!H', len(payload))
+        mask = os.urandom(4)
+        masked = bytes(b ^ mask[x % 4] for x, b in enumerate(payload))
+        self.sock.sendall(header + mask + masked)
+
+    def receive_frame(self):
+        """Returns the opcode and payload, joining fragmented messages."""
+        opcode, payload, fin = None, b'', False
+        while not fin:
+            first, length = self.read(2)
+            fin = first & 0x80
+            if opcode is None:
+                opcode = first & 0x0f
+            if length == 126:
+                length, = struct.unpack('!H', self.read(2))
+            elif length == 127:
+                length, = struct.unpack('!Q', self.read(8))
+            payload += self.read(length)
+        return opcode, payload
+
+    def send(self, message):
+        self.send_frame(0x1, json.dumps(message).encode())
+
+    def receive(self):
+        """Returns the next message or None once the server has closed."""
+        while True:
+            opcode, payload = self.receive_frame()
+            if opcode == 0x1:
+                return json.loads(payload)
+            if opcode == 0x8:
+                return None
+
+    def receive_result(self, command_id):
+        while True:
+            message = self.receive()
+            if message is None or (message['type'] == 'result' and message['id'] == command_id):
+                return message
+
+    def receive_event(self, name):
+        while True:
+            message = self.receive()
+            if message is None or (message['type'] == 'event' and message['event'] == name):
+                return message
+
+    def close(self):
+        self.send_frame(0x8, struct.pack('!H', 1000))
+        reply = self.receive()
+        self.sock.close()
+        return reply
 
 
 class TestUI(unittest.TestCase):
@@ -21,19 +119,32 @@ class TestUI(unittest.TestCase):
         r = requests.get('%s/games/game_token' % SERVER)
         self.assertTrue('<html>' in r.text)
 
+    def test_tv_view(self):
+        r = requests.get('%s/games/game_token/tv' % SERVER)
+        self.assertTrue('<html>' in r.text)
+
 
 class TestAPI(unittest.TestCase):
     def setUp(self):
         self.player_token = {}
+        # Settings for the games of the chained tests:
+        self.game_options = dict(NO_WORD_RULES)
+        self.num_cards = 6
+        # Called once the game of the chained tests has started:
+        self.on_game_start = None
 
     def api(self, path):
         return '%s/api%s' % (SERVER, path)
 
+    def fixed_word(self, board, num_tiles=3):
+        """Lays the first num_tiles of the player's tiles, letters first."""
+        tiles = [t for t in board['self']['tiles'] if t != '*']
+        tiles.sort(key=lambda t: t == '\u00a0')
+        return ''.join(tiles[:num_tiles])
+
     def test_new_game(self):
         p = '/games'
-        r = requests.post(self.api(p), json={
-            'player_name': 'Player 1',
-        })
+        r = requests.post(self.api(p), json=dict(self.game_options, player_name='Player 1'))
         self.assertEqual(r.status_code, 201)
         j = r.json()
         self.game_token = j.get('game_token')
@@ -181,11 +292,29 @@ class TestAPI(unittest.TestCase):
         })
         self.assertEqual(r.status_code, 400)
 
+    def test_player_join_bad_next_round(self):
+        self.test_new_game()
+        p = '/games/%s/players' % self.game_token
+        r = requests.post(self.api(p), json={
+            'player_name': 'Foo',
+            'next_round': 'yes',
+        })
+        self.assertEqual(r.status_code, 400)
+        self.assertEqual(r.json()['error'], 'invalid next_round')
+        r = requests.get(self.api(p))
+        self.assertEqual(r.json()['players'], ['Player 1'])
+
     def test_player_list_invalid_game_token(self):
         p = '/games/%s/players' % '123'
         r = requests.get(self.api(p))
         self.assertEqual(r.status_code, 404)
 
+    def test_self_without_session(self):
+        self.test_new_game()
+        p = '/games/%s/self' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 401)
+
     def test_zero_score(self):
         self.test_player_join()
         p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
@@ -229,6 +358,22 @@ class TestAPI(unittest.TestCase):
         self.assertEqual(j['players'][1]['is_self'], False)
         self.assertEqual(j['players'][2]['is_self'], False)
 
+    def test_board_version(self):
+        self.test_player_join()
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        version = r.json()['version']
+
+        p = '/games/%s/players/%s/ready' % (self.game_token, self.player_token[1])
+        r = requests.put(self.api(p))
+        self.assertEqual(r.status_code, 200)
+
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        self.assertTrue(r.json()['version'] > version)
+
     def test_game_start(self):
         self.test_player_ready()
         p = '/games/%s/players/%s/ready' % (self.game_token, self.player_token[1])
@@ -254,13 +399,16 @@ class TestAPI(unittest.TestCase):
             self.assertEqual(j['players'][x]['name'], 'Player %d' % (x+1))
             self.assertEqual(j['players'][x]['is_ready'], True)
             self.assertTrue(isinstance(j['players'][x]['id'], int))
-            self.assertEqual(len(j['players'][x]['letters']), 12)
+            # Other players' letters are hidden until all words are submitted:
+            self.assertEqual(len(j['players'][x]['tiles']), 12 if x == 0 else 0)
 
         # random, no further check
         self.assertTrue(len(j['self']['card']['text']) > 4)
         self.assertEqual(j['players'][0]['letters'], j['self']['letters'])
 
         self.assertEqual(j['round'], 1)
+        if self.on_game_start:
+            self.on_game_start()
 
     def test_no_join_after_game_start(self):
         self.test_game_start()
@@ -286,9 +434,9 @@ class TestAPI(unittest.TestCase):
             j = r.json()
             self.assertEqual(j['phase'], 'submit-word')
 
-            word = j['self']['letters'][1:4]
+            word = self.fixed_word(j)
             if loop == 0:
-                word = j['self']['letters']
+                word = self.fixed_word(j, 12)
             p = '/games/%s/players/%s/word' % (self.game_token, self.player_token[x])
             r = requests.put(self.api(p), json={
                 'word': word,
@@ -340,6 +488,24 @@ class TestAPI(unittest.TestCase):
         })
         self.assertEqual(r.status_code, 400)
 
+    def test_tiles(self):
+        self.test_game_start()
+        j = self.get_boards()[0]
+        tiles = j['self']['tiles']
+        self.assertEqual(j['self']['letters'], ''.join(tiles))
+        for tile in tiles:
+            self.assertTrue(1 <= len(tile) <= 3)
+
+        # Multi-letter tiles can only be laid as a whole:
+        p = '/games/%s/players/%s/word' % (self.game_token, self.player_token[0])
+        for tile in tiles:
+            if len(tile) > 1 and tile[0] not in tiles:
+                r = requests.put(self.api(p), json={'word': tile[0]})
+                self.assertEqual(r.status_code, 400)
+                self.assertEqual(r.json()['error'], 'invalid letter')
+        r = requests.put(self.api(p), json={'word': self.fixed_word(j, len(tiles))})
+        self.assertEqual(r.status_code, 200)
+
     def test_assign_all_words(self):
         self.test_submit_word()
         for loop, player_token in enumerate(self.player_token.values()):
@@ -353,7 +519,7 @@ class TestAPI(unittest.TestCase):
                 if player['all_words_assigned']:
                     players_with_all_words_assigned += 1
             self.assertEqual(players_with_all_words_assigned, loop)
-            self.assertEqual(len(j['cards']), 3+3)
+            self.assertEqual(len(j['cards']), self.num_cards)
             usable_card_ids = []
             for card in j['cards']:
                 if card['is_self']:
@@ -361,7 +527,7 @@ class TestAPI(unittest.TestCase):
                 usable_card_ids.append(card['id'])
             guesses = {}
             for player in j['players']:
-                if player['is_self']:
+                if player['is_self'] or player['is_queued']:
                     continue
                 guesses[str(player['id'])] = usable_card_ids.pop(0)
             p = '/games/%s/players/%s/guesses' % (self.game_token, player_token)
@@ -384,7 +550,7 @@ class TestAPI(unittest.TestCase):
             j = r.json()
             self.assertEqual(j['phase'], 'score')
             for player in j['players']:
-                self.assertEqual(player['all_words_assigned'], True)
+                self.assertEqual(player['all_words_assigned'], not player['is_queued'])
 
     def test_assign_words_fail_on_missing_player(self):
         self.test_submit_word()
@@ -521,15 +687,16 @@ class TestAPI(unittest.TestCase):
         j = r.json()
         self.assertEqual(j['phase'], 'score')
         self.assertTrue(isinstance(j['currently_scored']['word'], str))
-        # Test words are always 3 or 12 (all letters) letters long:
-        self.assertTrue(len(j['currently_scored']['word']) in (3, 12))
+        # Test words are always laid with 3 or 12 (all) tiles, which hold
+        # at least one letter each:
+        self.assertTrue(len(j['currently_scored']['word']) >= 3)
         self.assertTrue(isinstance(j['currently_scored']['player_id'], int))
         self.assertEqual(len(list(j['currently_scored']['guesses'].keys())), 2)
         self.assertTrue(isinstance(list(j['currently_scored']['guesses'].keys())[0], str))
         self.assertTrue(isinstance(list(j['currently_scored']['guesses'].keys())[1], str))
         self.assertTrue(isinstance(list(j['currently_scored']['guesses'].values())[0], int))
         self.assertTrue(isinstance(list(j['currently_scored']['guesses'].values())[1], int))
-        self.assertEqual(len(j['cards']), 6)
+        self.assertEqual(len(j['cards']), self.num_cards)
         for card in j['cards']:
             self.assertTrue('score' in card)
             if card['is_self']:
@@ -571,8 +738,1026 @@ class TestAPI(unittest.TestCase):
             if card.get('player_id'):
                 revealed_cards += 1
         self.assertEqual(revealed_cards, 3)
-        self.assertEqual(j['phase'], 'wait-for-ready')
+        if self.game_options.get('max_rounds') == 1:
+            self.assertEqual(j['phase'], 'game-over')
+        else:
+            self.assertEqual(j['phase'], 'wait-for-ready')
+
+    def test_rounds(self):
+        self.test_score()
+        p = '/games/%s/rounds' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        j = r.json()
+        self.assertEqual([player['name'] for player in j['players']], ['Player 1', 'Player 3', 'Player 2'])
+        self.assertEqual(len(j['rounds']), 1)
+        rnd = j['rounds'][0]
+        self.assertEqual(rnd['round'], 1)
+        self.assertEqual(len(rnd['cards']), 6)
+        own_cards = [card for card in rnd['cards'] if card['player_id']]
+        self.assertEqual(len(own_cards), 3)
+        for card in own_cards:
+            self.assertTrue(len(card['word']) >= 3)
+            self.assertEqual(len(card['tiles']), 12)
+            self.assertEqual(card['letters'], ''.join(card['tiles']))
+        self.assertEqual(len(rnd['guesses']), 3)
+        for guesses in rnd['guesses'].values():
+            self.assertEqual(len(guesses), 2)
+
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
+        board = requests.get(self.api(p)).json()
+        for player in board['players']:
+            points = rnd['points'][str(player['id'])]
+            self.assertEqual(points['score_total'], player['score_total'])
+            self.assertEqual(points['score_own_words'], player['score_own_words'])
+            self.assertEqual(j['score_series'][str(player['id'])], [player['score_total']])
+
+        p = '/games/%s/rounds/1' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(r.json(), rnd)
+
+    def test_round_unfinished(self):
+        self.test_game_start()
+        p = '/games/%s/rounds' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(r.json()['rounds'], [])
+        p = '/games/%s/rounds/1' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 404)
+        p = '/games/%s/rounds/x' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 400)
+
+    def create_profile(self, name):
+        s = requests.Session()
+        r = s.post(self.api('/profiles'), json={'profile_name': name})
+        self.assertEqual(r.status_code, 201)
+        return s, r.json()['id']
+
+    def test_profile(self):
+        s, profile_id = self.create_profile('Profile 1')
+        r = s.get(self.api('/profile'))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(r.json(), {'id': profile_id, 'name': 'Profile 1'})
+        r = requests.get(self.api('/profile'))
+        self.assertEqual(r.status_code, 404)
+        r = requests.post(self.api('/profiles'), json={'profile_name': ''})
+        self.assertEqual(r.status_code, 400)
+
+    def test_profile_linked_on_join(self):
+        s, profile_id = self.create_profile('Profile 1')
+        r = s.post(self.api('/games'), json={'player_name': 'Player 1'})
+        self.assertEqual(r.status_code, 201)
+        game_token = r.json()['game_token']
+        player_token = r.json()['player_token']
+        p = '/games/%s/players/%s/profile' % (game_token, player_token)
+        r = s.put(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        s2, _ = self.create_profile('Profile 2')
+        r = s2.put(self.api(p))
+        self.assertEqual(r.status_code, 409)
+
+        # Only one seat per profile and game:
+        p = '/games/%s/players' % game_token
+        r = s.post(self.api(p), json={'player_name': 'Player 2'})
+        self.assertEqual(r.status_code, 201)
+        p = '/games/%s/players/%s/profile' % (game_token, r.json()['player_token'])
+        r = s.put(self.api(p))
+        self.assertEqual(r.status_code, 409)
+
+    def test_profile_stats(self):
+        self.on_game_start = lambda: self.assertEqual(self.take_mulligan(0).status_code, 200)
+        self.test_score()
+        s, profile_id = self.create_profile('Profile 1')
+        p = '/games/%s/players/%s/profile' % (self.game_token, self.player_token[0])
+        r = requests.put(self.api(p))
+        self.assertEqual(r.status_code, 401)
+        r = s.put(self.api(p))
+        self.assertEqual(r.status_code, 200)
+
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
+        board = requests.get(self.api(p)).json()
+        best = max(player['score_total'] for player in board['players'])
+        me = [player for player in board['players'] if player['is_self']][0]
+
+        r = requests.get(self.api('/profiles/%d/stats' % profile_id))
+        self.assertEqual(r.status_code, 200)
+        j = r.json()
+        self.assertEqual(j['name'], 'Profile 1')
+        self.assertEqual(j['games_played'], 1)
+        self.assertEqual(j['games_won'], 1 if best and me['score_total'] == best else 0)
+        self.assertEqual(j['score_total'], me['score_total'])
+        self.assertEqual(j['guesses_total'], 2)
+        self.assertEqual(j['guesses_correct'], me['score_correct_guesses'])
+        self.assertEqual(j['words_total'], 1)
+        self.assertEqual(j['times_guessed'], me['score_own_words'])
+        self.assertEqual(j['mulligans'], 1)
+        # The shipped cards have no categories:
+        self.assertEqual(j['categories'], [])
+
+        r = requests.get(self.api('/leaderboard'))
+        self.assertEqual(r.status_code, 200)
+        self.assertTrue(profile_id in [row['id'] for row in r.json()['leaderboard']])
+
+        r = requests.get(self.api('/profiles/0/stats'))
+        self.assertEqual(r.status_code, 404)
+
+    def get_card_report(self):
+        r = requests.get(self.api('/admin/cards/report'), headers={
+            'Authorization': 'Bearer %s' % ADMIN_TOKEN,
+        })
+        self.assertEqual(r.status_code, 200)
+        return r.json()
+
+    def test_card_report(self):
+        r = requests.get(self.api('/admin/cards/report'))
+        self.assertEqual(r.status_code, 401)
+        r = requests.get(self.api('/admin/cards/report'), headers={
+            'Authorization': 'Bearer wrong',
+        })
+        self.assertEqual(r.status_code, 401)
+
+        before = self.get_card_report()['cards']
+        self.test_score()
+        after = self.get_card_report()['cards']
+        difficulties = [card['difficulty'] for card in after]
+        self.assertEqual(difficulties, sorted(difficulties, reverse=True))
+
+        def total(cards, key):
+            return sum(card[key] for card in cards)
+        guesses = total(after, 'times_guessed') + total(after, 'times_missed')
+        guesses -= total(before, 'times_guessed') + total(before, 'times_missed')
+        self.assertEqual(guesses, 3 * 2)
+        # Every wrong guess picks another card:
+        self.assertEqual(total(after, 'times_missed') - total(before, 'times_missed'),
+                         total(after, 'times_decoy') - total(before, 'times_decoy'))
+        for card in after:
+            if card['times_guessed'] + card['times_missed'] + card['times_decoy'] == 0:
+                self.assertEqual(card['difficulty'], 0.5)
+
+    def start_game_with(self, options, session=requests, num_cards=6):
+        r = session.post(self.api('/games'), json=dict(NO_WORD_RULES, player_name='Player 1', **options))
+        self.assertEqual(r.status_code, 201)
+        self.game_token = r.json()['game_token']
+        self.player_token[0] = r.json()['player_token']
+        for x in (1, 2):
+            p = '/games/%s/players' % self.game_token
+            r = requests.post(self.api(p), json={'player_name': 'Player %d' % (x + 1)})
+            self.assertEqual(r.status_code, 201)
+            self.player_token[x] = r.json()['player_token']
+        for player_token in self.player_token.values():
+            p = '/games/%s/players/%s/ready' % (self.game_token, player_token)
+            r = requests.put(self.api(p))
+            self.assertEqual(r.status_code, 200)
+        for j in self.get_boards():
+            self.assertEqual(j['phase'], 'submit-word')
+            self.assertEqual(len(j['cards']), num_cards)
+            self.assertEqual(len(set(card['id'] for card in j['cards'])), num_cards)
+
+    def test_new_game_card_difficulty(self):
+        r = requests.post(self.api('/games'), json={
+            'player_name': 'Player 1',
+            'card_difficulty': 2,
+        })
+        self.assertEqual(r.status_code, 400)
+        self.start_game_with({'card_difficulty': 0.8})
+
+    def test_new_game_card_selection(self):
+        r = requests.post(self.api('/games'), json={
+            'player_name': 'Player 1',
+            'card_selection': 'invalid',
+        })
+        self.assertEqual(r.status_code, 400)
+        for selection in ('least-used', 'random', 'category', 'difficulty', 'avoid-seen'):
+            self.start_game_with({'card_selection': selection})
+
+    def test_avoid_seen_cards(self):
+        s, _ = self.create_profile('Profile 1')
+        self.start_game_with({}, session=s)
+        seen = set(card['id'] for card in self.get_boards()[0]['cards'])
+        self.start_game_with({'card_selection': 'avoid-seen'}, session=s)
+        cards = set(card['id'] for card in self.get_boards()[0]['cards'])
+        self.assertEqual(seen & cards, set())
+
+    def test_profile_seen_cards_reset(self):
+        s, _ = self.create_profile('Profile 1')
+        self.start_game_with({}, session=s)
+        r = s.delete(self.api('/profile/seen-cards'))
+        self.assertEqual(r.status_code, 200)
+        r = requests.delete(self.api('/profile/seen-cards'))
+        self.assertEqual(r.status_code, 401)
+
+    def create_room(self):
+        r = requests.post(self.api('/rooms'))
+        self.assertEqual(r.status_code, 201)
+        return r.json()['room_token']
+
+    def get_room(self, room_token):
+        r = requests.get(self.api('/rooms/%s' % room_token))
+        self.assertEqual(r.status_code, 200)
+        return r.json()
+
+    def test_room(self):
+        r = requests.get(self.api('/rooms/invalid'))
+        self.assertEqual(r.status_code, 404)
+        r = requests.post(self.api('/games'), json={
+            'player_name': 'Player 1',
+            'room_token': 'invalid',
+        })
+        self.assertEqual(r.status_code, 400)
+
+        room_token = self.create_room()
+        self.assertEqual(self.get_room(room_token)['seen_cards'], 0)
+        self.start_game_with({'room_token': room_token})
+        seen = set(card['id'] for card in self.get_boards()[0]['cards'])
+        self.start_game_with({'room_token': room_token})
+        cards = set(card['id'] for card in self.get_boards()[0]['cards'])
+        self.assertEqual(seen & cards, set())
+        j = self.get_room(room_token)
+        self.assertEqual(j['seen_cards'], 12)
+        self.assertGreater(j['total_cards'], 12)
+
+        r = requests.delete(self.api('/rooms/%s/seen-cards' % room_token))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(self.get_room(room_token)['seen_cards'], 0)
+
+    def test_room_exhausted(self):
+        room_token = self.create_room()
+        total = self.get_room(room_token)['total_cards']
+        # Start over with all cards once they have all been seen:
+        for x in range(total // 6 + 2):
+            self.start_game_with({'room_token': room_token})
+        self.assertLess(self.get_room(room_token)['seen_cards'], total)
+
+    def get_lobby_tokens(self):
+        r = requests.get(self.api('/lobby'))
+        self.assertEqual(r.status_code, 200)
+        return [g['game_token'] for g in r.json()['games']]
+
+    def test_lobby(self):
+        for options in ({'language': 'xx'}, {'max_players': 2}, {'max_players': 100}):
+            r = requests.post(self.api('/games'), json=dict(options, player_name='Player 1'))
+            self.assertEqual(r.status_code, 400)
+
+        self.test_player_join()
+        self.assertNotIn(self.game_token, self.get_lobby_tokens())
+
+        r = requests.post(self.api('/games'), json={
+            'player_name': 'Player 1',
+            'public': True,
+            'max_players': 3,
+        })
+        self.assertEqual(r.status_code, 201)
+        game_token = r.json()['game_token']
+        r = requests.get(self.api('/lobby'))
+        game = [g for g in r.json()['games'] if g['game_token'] == game_token][0]
+        self.assertEqual(game['num_players'], 1)
+        self.assertEqual(game['max_players'], 3)
+        self.assertEqual(game['language'], 'de')
+        self.assertEqual(game['card_selection'], 'least-used')
+
+        # The fullest game is joined first:
+        r = requests.post(self.api('/games/%s/players' % game_token), json={'player_name': 'Player 2'})
+        self.assertEqual(r.status_code, 201)
+        r = requests.post(self.api('/lobby/join'), json={'player_name': 'Player 3'})
+        self.assertEqual(r.status_code, 201)
+        self.assertEqual(r.json()['game_token'], game_token)
+        self.assertRegex(r.json()['player_token'], '[a-z0-9]{12}')
+
+        # Full games are no longer listed nor joinable:
+        self.assertNotIn(game_token, self.get_lobby_tokens())
+        r = requests.post(self.api('/games/%s/players' % game_token), json={'player_name': 'Player 4'})
+        self.assertEqual(r.status_code, 409)
+
+    def test_lobby_quick_join(self):
+        r = requests.post(self.api('/lobby/join'), json={'player_name': 'Player 1', 'language': 'xx'})
+        self.assertEqual(r.status_code, 404)
+
+        r = requests.post(self.api('/games'), json={'player_name': 'Player 1', 'public': True})
+        self.assertEqual(r.status_code, 201)
+        self.game_token = r.json()['game_token']
+        self.player_token[0] = r.json()['player_token']
+        self.assertIn(self.game_token, self.get_lobby_tokens())
+        for x in (1, 2):
+            r = requests.post(self.api('/lobby/join'), json={'player_name': 'Quick %d' % x})
+            self.assertEqual(r.status_code, 201)
+            self.assertEqual(r.json()['game_token'], self.game_token)
+            self.player_token[x] = r.json()['player_token']
+
+        # Started games are no longer listed:
+        for player_token in self.player_token.values():
+            p = '/games/%s/players/%s/ready' % (self.game_token, player_token)
+            r = requests.put(self.api(p))
+            self.assertEqual(r.status_code, 200)
+        self.assertNotIn(self.game_token, self.get_lobby_tokens())
+
+    def test_join_code(self):
+        self.test_new_game()
+        r = requests.get(self.api('/games/%s/invitation' % self.game_token))
+        self.assertEqual(r.status_code, 200)
+        join_code = r.json()['join_code']
+        # No letters which sound alike, like M and N or F and S:
+        self.assertRegex(join_code, '^[AHJKLMRSWXY]{3}-[2-9]{4}$')
+        self.assertTrue(r.json()['join_url'].endswith('/j/%s' % join_code))
+
+        # Codes are accepted as typed:
+        for code in (join_code, join_code.lower().replace('-', '')):
+            r = requests.get(self.api('/join-codes/%s' % code))
+            self.assertEqual(r.status_code, 200)
+            self.assertEqual(r.json()['game_token'], self.game_token)
+        r = requests.get(self.api('/join-codes/OOO-0000'))
+        self.assertEqual(r.status_code, 404)
+
+        r = requests.get('%s/j/%s' % (SERVER, join_code), allow_redirects=False)
+        self.assertEqual(r.status_code, 302)
+        self.assertTrue(r.headers['Location'].endswith('/games/%s' % self.game_token))
+
+        r = requests.get(self.api('/games/%s/invitation/qr.png' % self.game_token))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(r.headers['Content-Type'], 'image/png')
+        self.assertTrue(r.content.startswith(b'\x89PNG'))
+        r = requests.get(self.api('/games/%s/invitation/qr.svg' % self.game_token))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(r.headers['Content-Type'], 'image/svg+xml')
+        self.assertTrue(r.text.startswith('<svg'))
+
+        # Codes expire once the game has started:
+        self.test_player_join()
+        r = requests.get(self.api('/games/%s/invitation' % self.game_token))
+        join_code = r.json()['join_code']
+        for player_token in self.player_token.values():
+            p = '/games/%s/players/%s/ready' % (self.game_token, player_token)
+            r = requests.put(self.api(p))
+            self.assertEqual(r.status_code, 200)
+        r = requests.get(self.api('/join-codes/%s' % join_code))
+        self.assertEqual(r.status_code, 404)
+
+    def test_settings(self):
+        self.game_options = {}
+        self.test_player_join()
+        p = '/games/%s/settings' % self.game_token
+        r = requests.get(self.api(p))
+        self.assertEqual(r.status_code, 200)
+        j = r.json()
+        self.assertEqual(j['host'], 'Player 1')
+        self.assertEqual(j['min_players'], 3)
+        self.assertEqual(j['max_rounds'], 0)
+        self.assertEqual(j['num_spaces'], 2)
+        self.assertEqual(j['min_word_length'], 2)
+        self.assertEqual(j['require_vowel'], True)
+        self.assertEqual(j['ban_card_words'], True)
+        self.assertEqual(j['ban_dictionary_words'], False)
+
+        p = '/games/%s/players/%%s/settings' % self.game_token
+        r = requests.patch(self.api(p % self.player_token[1]), json={'max_rounds': 5})
+        self.assertEqual(r.status_code, 403)
+        for settings in ({'min_players': 2}, {'max_players': 2}, {'num_vocals': 0},
+                         {'num_spaces': 9}, {'max_rounds': -1}, {'language': 'xx'},
+                         {'mulligans': 9}, {'mulligan_scope': 'xx'}, {'num_jokers': 9},
+                         {'min_word_length': 0}, {'min_word_length': 99}):
+            r = requests.patch(self.api(p % self.player_token[0]), json=settings)
+            self.assertEqual(r.status_code, 400)
+
+        r = requests.patch(self.api(p % self.player_token[0]), json={
+            'min_players': 4,
+            'num_vocals': 3,
+            'num_spaces': 0,
+        })
+        self.assertEqual(r.status_code, 200)
+        j = requests.get(self.api('/games/%s/settings' % self.game_token)).json()
+        self.assertEqual(j['min_players'], 4)
+        self.assertEqual(j['num_spaces'], 0)
+        self.assertEqual(j['num_consonants'], 6)
+
+        # The game waits for the fourth player:
+        for player_token in self.player_token.values():
+            r = requests.put(self.api('/games/%s/players/%s/ready' % (self.game_token, player_token)))
+            self.assertEqual(r.status_code, 200)
+        self.assertEqual(self.get_boards()[0]['phase'], 'wait-for-ready')
+        r = requests.post(self.api('/games/%s/players' % self.game_token), json={'player_name': 'Player 4'})
+        self.assertEqual(r.status_code, 201)
+        self.player_token[3] = r.json()['player_token']
+        r = requests.put(self.api('/games/%s/players/%s/ready' % (self.game_token, self.player_token[3])))
+        self.assertEqual(r.status_code, 200)
+        board = self.get_boards()[0]
+        self.assertEqual(board['phase'], 'submit-word')
+        self.assertEqual(len(board['self']['tiles']), 9)
+
+        r = requests.patch(self.api(p % self.player_token[0]), json={'max_rounds': 5})
+        self.assertEqual(r.status_code, 403)
+
+    def test_game_over(self):
+        self.game_options = dict(NO_WORD_RULES, max_rounds=1)
+        self.test_score()
+        p = '/games/%s/players/%s/ready' % (self.game_token, self.player_token[0])
+        r = requests.put(self.api(p))
+        self.assertEqual(r.status_code, 403)
+
+    def test_decoys(self):
+        for options in ({'decoy_mode': 'x'}, {'decoy_mode': 'fixed', 'decoys': 11},
+                        {'decoy_mode': 'total', 'decoys': 0}):
+            r = requests.post(self.api('/games'), json=dict(options, player_name='Player 1'))
+            self.assertEqual(r.status_code, 400)
+        self.start_game_with({'decoy_mode': 'fixed', 'decoys': 4}, num_cards=7)
+        self.start_game_with({'decoy_mode': 'total', 'decoys': 10}, num_cards=10)
+        # At least one decoy is left when filling up:
+        self.start_game_with({'decoy_mode': 'total', 'decoys': 2}, num_cards=4)
+
+    def test_decoys_zero(self):
+        self.game_options = dict(NO_WORD_RULES, decoy_mode='fixed', decoys=0)
+        self.num_cards = 3
+        self.test_score()
+
+    def take_mulligan(self, x):
+        p = '/games/%s/players/%s/mulligan' % (self.game_token, self.player_token[x])
+        return requests.put(self.api(p))
+
+    def test_mulligan(self):
+        self.test_game_start()
+        ws = self.open_websocket()
+        board = self.get_boards()[0]
+        self.assertEqual(board['self']['mulligans_left'], 1)
+        r = self.take_mulligan(0)
+        self.assertEqual(r.status_code, 200)
+        # Everybody is told who has redrawn:
+        me = [player for player in board['players'] if player['is_self']][0]
+        event = ws.receive_event('mulligan')
+        self.assertEqual(event['data'], {'player_id': me['id'], 'name': 'Player 1', 'round': 1})
+        board = self.get_boards()[0]
+        self.assertEqual(len(board['self']['tiles']), 12)
+        self.assertEqual(board['self']['mulligans_left'], 0)
+        r = self.take_mulligan(0)
+        self.assertEqual(r.status_code, 403)
+
+        # No redraws after submitting the word:
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[1])
+        board = requests.get(self.api(p)).json()
+        r = requests.put(self.api(p + '/word'), json={'word': self.fixed_word(board)})
+        self.assertEqual(r.status_code, 200)
+        r = self.take_mulligan(1)
+        self.assertEqual(r.status_code, 403)
+        self.assertEqual(r.json()['error'], 'word already submitted')
+
+    def test_mulligan_disabled(self):
+        self.start_game_with({'mulligans': 0})
+        self.assertEqual(self.get_boards()[0]['self']['mulligans_left'], 0)
+        r = self.take_mulligan(0)
+        self.assertEqual(r.status_code, 403)
+
+    def test_mulligan_per_game(self):
+        self.game_options = dict(NO_WORD_RULES, mulligan_scope='game')
+        self.on_game_start = lambda: self.assertEqual(self.take_mulligan(2).status_code, 200)
+        self.test_score()
+        for player_token in self.player_token.values():
+            r = requests.put(self.api('/games/%s/players/%s/ready' % (self.game_token, player_token)))
+            self.assertEqual(r.status_code, 200)
+        for x, mulligans_left in ((0, 1), (2, 0)):
+            p = '/games/%s/players/%s' % (self.game_token, self.player_token[x])
+            board = requests.get(self.api(p)).json()
+            self.assertEqual(board['round'], 2)
+            self.assertEqual(board['self']['mulligans_left'], mulligans_left)
+        self.assertEqual(self.take_mulligan(2).status_code, 403)
+
+        r = requests.get(self.api('/games/%s/rounds/1' % self.game_token))
+        redraws = sorted(card['redraws'] for card in r.json()['cards'])
+        self.assertEqual(redraws[-2:], [0, 1])
+
+    def submit_joker_word(self, x, jokers=[0]):
+        """Submits 'Q' for the joker, followed by two regular tiles."""
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[x])
+        board = requests.get(self.api(p)).json()
+        word = 'Q' + self.fixed_word(board, 2)
+        return requests.put(self.api(p + '/word'), json={'word': word, 'jokers': jokers}), word
+
+    def test_jokers(self):
+        self.start_game_with({'num_jokers': 2})
+        board = self.get_boards()[0]
+        self.assertEqual(len(board['self']['tiles']), 14)
+        self.assertEqual(board['self']['tiles'].count('*'), 2)
+
+        p = '/games/%s/players/%s/word' % (self.game_token, self.player_token[0])
+        r = requests.put(self.api(p), json={'word': '*'})
+        self.assertEqual(r.status_code, 400)
+        for jokers in ([99], [0, 0], [-1]):
+            r, _ = self.submit_joker_word(0, jokers)
+            self.assertEqual(r.status_code, 400)
+            self.assertEqual(r.json()['error'], 'invalid joker')
+        r, word = self.submit_joker_word(0)
+        self.assertEqual(r.status_code, 200)
+        board = self.get_boards()[0]
+        self.assertEqual(board['self']['word'], word)
+        self.assertEqual(board['self']['jokers'], [0])
+
+    def test_joker_sharp_s(self):
+        # ß has no single upper case letter, so it's laid as it is:
+        self.start_game_with({'num_jokers': 1})
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
+        word = 'ß' + self.fixed_word(requests.get(self.api(p)).json(), 2)
+        r = requests.put(self.api(p + '/word'), json={'word': word, 'jokers': [0]})
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(self.get_boards()[0]['self']['word'], word)
+
+    def test_jokers_exhausted(self):
+        self.start_game_with({'num_jokers': 1})
+        p = '/games/%s/players/%s/word' % (self.game_token, self.player_token[0])
+        r = requests.put(self.api(p), json={'word': 'QQ', 'jokers': [0, 1]})
+        self.assertEqual(r.status_code, 400)
+        self.assertEqual(r.json()['error'], 'invalid letter')
+
+    def test_word_rules(self):
+        self.start_game_with({
+            'num_jokers': 3,
+            'min_word_length': 2,
+            'require_vowel': True,
+            'ban_card_words': True,
+        })
+        p = '/games/%s/players/%s/word' % (self.game_token, self.player_token[0])
+
+        def submit(word, jokers=[]):
+            r = requests.put(self.api(p), json={'word': word, 'jokers': jokers})
+            self.assertEqual(r.status_code, 400)
+            return r.json().get('code', r.json()['error'])
+
+        self.assertEqual(submit(''), 'no word submitted')
+        self.assertEqual(submit('\u00a0\u00a0'), 'word_too_short')
+        self.assertEqual(submit('X', [0]), 'word_too_short')
+        self.assertEqual(submit('XZ', [0, 1]), 'word_without_vowel')
+        # Words breaking the rules are still checked for the letters, there
+        # is no single Q tile:
+        self.assertEqual(submit('QQQQ', [0, 1, 2]), 'invalid letter')
+        # The message is meant for people, the code for clients:
+        r = requests.put(self.api(p), json={'word': 'X', 'jokers': [0]})
+        self.assertEqual(r.json(), {'error': 'word too short', 'code': 'word_too_short'})
+        ws = self.open_websocket()
+        ws.send({'id': 1, 'type': 'auth', 'player_token': self.player_token[0]})
+        self.assertEqual(ws.receive_result(1)['status'], 200)
+        ws.send({'id': 2, 'type': 'word', 'word': 'X', 'jokers': [0]})
+        result = ws.receive_result(2)
+        self.assertEqual(result['status'], 400)
+        self.assertEqual(result['code'], 'word_too_short')
+
+        # No card has this word:
+        r = requests.put(self.api(p), json={'word': 'ZOX', 'jokers': [0, 1, 2]})
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(self.get_boards()[0]['self']['word'], 'ZOX')
+
+    def test_word_rules_disabled(self):
+        self.start_game_with({'num_jokers': 1})
+        for x in range(3):
+            p = '/games/%s/players/%s/word' % (self.game_token, self.player_token[x])
+            r = requests.put(self.api(p), json={'word': 'X', 'jokers': [0]})
+            self.assertEqual(r.status_code, 200)
+        self.assertEqual(self.get_boards()[0]['phase'], 'assign-words')
+
+    def test_word_rules_dictionary(self):
+        # The tests run without word lists:
+        r = requests.post(self.api('/games'), json={'player_name': 'Player 1', 'ban_dictionary_words': True})
+        self.assertEqual(r.status_code, 400)
+        self.assertEqual(r.json()['error'], 'invalid ban_dictionary_words')
+
+    def test_joker_penalty(self):
+        self.start_game_with({'num_jokers': 1, 'joker_penalty': True})
+        for x in range(3):
+            r, _ = self.submit_joker_word(x)
+            self.assertEqual(r.status_code, 200)
+        # Everyone guesses right, so every word is guessed twice:
+        boards = self.get_boards()
+        card_by_player = {}
+        for board in boards:
+            self_id = [player['id'] for player in board['players'] if player['is_self']][0]
+            card_by_player[self_id] = board['self']['card']['id']
+        for x, board in enumerate(boards):
+            guesses = {str(player_id): card_id for player_id, card_id in card_by_player.items()
+                       if card_id != board['self']['card']['id']}
+            p = '/games/%s/players/%s/guesses' % (self.game_token, self.player_token[x])
+            r = requests.put(self.api(p), json={'guesses': guesses})
+            self.assertEqual(r.status_code, 200)
+        for _ in range(3):
+            for player_token in self.player_token.values():
+                requests.put(self.api('/games/%s/players/%s/scored' % (self.game_token, player_token)))
+        for board in self.get_boards():
+            self.assertEqual(board['phase'], 'wait-for-ready')
+            for player in board['players']:
+                self.assertEqual(player['score_correct_guesses'], 2)
+                self.assertEqual(player['score_own_words'], 1)
+                self.assertEqual(player['score_total'], 3)
+            own_card = [card for card in board['cards'] if card['is_self']][0]
+            self.assertEqual(own_card['score'], 1)
+        r = requests.get(self.api('/games/%s/rounds/1' % self.game_token))
+        for points in r.json()['points'].values():
+            self.assertEqual(points['score_own_words'], 1)
+            self.assertEqual(points['score_total'], 3)
+
+    def get_public_board(self):
+        r = requests.get(self.api('/games/%s/board' % self.game_token))
+        self.assertEqual(r.status_code, 200)
+        return r.json()
+
+    def test_spectator(self):
+        self.test_game_start()
+        board = self.get_public_board()
+        self.assertEqual(board['phase'], 'submit-word')
+        self.assertNotIn('self', board)
+        self.assertEqual(len(board['players']), 3)
+        self.assertEqual(len(board['cards']), 6)
+        for card in board['cards']:
+            self.assertEqual(card['player_id'], None)
+        for player in board['players']:
+            self.assertFalse(player['is_self'])
+        # Nobody's letters or words are shown while they're being laid:
+        for x in range(2):
+            p = '/games/%s/players/%s' % (self.game_token, self.player_token[x])
+            board = requests.get(self.api(p)).json()
+            r = requests.put(self.api(p + '/word'), json={'word': self.fixed_word(board)})
+            self.assertEqual(r.status_code, 200)
+        board = self.get_public_board()
+        self.assertEqual(board['phase'], 'submit-word')
+        for player in board['players']:
+            self.assertEqual(player['letters'], '')
+            self.assertEqual(player['tiles'], [])
+            self.assertEqual(player['word'], '')
+
+    def test_spectator_score(self):
+        self.test_assign_all_words()
+        board = self.get_public_board()
+        self.assertEqual(board['phase'], 'score')
+        self.assertIn(board['currently_scored']['player_id'], [player['id'] for player in board['players']])
+        self.assertEqual(len(board['currently_scored']['guesses']), 2)
+        # Nothing has been revealed yet:
+        for card in board['cards']:
+            self.assertEqual(card['player_id'], None)
+        for player in board['players']:
+            self.assertTrue(player['all_words_assigned'])
+
+    def test_spectator_reveal(self):
+        self.test_score()
+        # The revealed cards of the last round stay on the table:
+        board = self.get_public_board()
+        self.assertEqual(board['phase'], 'wait-for-ready')
+        revealed = [card for card in board['cards'] if card['player_id']]
+        self.assertEqual(len(revealed), 3)
+
+    def test_join_next_round(self):
+        late = {}
+
+        def join_late():
+            p = '/games/%s/players' % self.game_token
+            r = requests.post(self.api(p), json={'player_name': 'Player 4'})
+            self.assertEqual(r.status_code, 403)
+            r = requests.post(self.api(p), json={'player_name': 'Player 4', 'next_round': True})
+            self.assertEqual(r.status_code, 201)
+            self.assertTrue(r.json()['queued'])
+            late['token'] = r.json()['player_token']
+            p = '/games/%s/players/%s' % (self.game_token, late['token'])
+            j = requests.get(self.api(p)).json()
+            self.assertTrue(j['self']['is_queued'])
+            self.assertEqual(j['cards'], [])
+            r = requests.put(self.api(p + '/word'), json={'word': 'ABC'})
+            self.assertEqual(r.status_code, 403)
+
+        self.on_game_start = join_late
+        self.test_score()
+        for player_token in list(self.player_token.values()):
+            r = requests.put(self.api('/games/%s/players/%s/ready' % (self.game_token, player_token)))
+            self.assertEqual(r.status_code, 200)
+
+        # The queued player gets a card in the next round:
+        p = '/games/%s/players/%s' % (self.game_token, late['token'])
+        j = requests.get(self.api(p)).json()
+        self.assertEqual(j['phase'], 'submit-word')
+        self.assertEqual(j['round'], 2)
+        self.assertFalse(j['self']['is_queued'])
+        self.assertTrue(len(j['self']['card']['text']) > 0)
+        self.assertEqual(len(j['cards']), 6)
+        late_player = [player for player in j['players'] if player['is_self']][0]
+        self.assertEqual(late_player['first_round'], 2)
+        self.assertEqual(late_player['score_total'], 0)
+
+        r = requests.get(self.api('/games/%s/rounds' % self.game_token))
+        self.assertEqual(r.json()['score_series'][str(late_player['id'])], [0])
+
+    def concurrently(self, requests_):
+        """Sends all requests at the same time, returns the status codes."""
+        barrier = threading.Barrier(len(requests_))
+        status_codes = [None] * len(requests_)
+
+        def send(i, method, path, json):
+            barrier.wait()
+            r = requests.request(method, self.api(path), json=json)
+            status_codes[i] = r.status_code
+        threads = [threading.Thread(target=send, args=(i,) + request)
+                   for i, request in enumerate(requests_)]
+        for t in threads:
+            t.start()
+        for t in threads:
+            t.join()
+        return status_codes
+
+    def get_boards(self):
+        boards = []
+        for player_token in self.player_token.values():
+            p = '/games/%s/players/%s' % (self.game_token, player_token)
+            r = requests.get(self.api(p))
+            self.assertEqual(r.status_code, 200)
+            boards.append(r.json())
+        return boards
+
+    def assert_visible_fields(self, words_visible, cards_revealed=False):
+        """Checks what each player and a spectator see of the others."""
+        for board in self.get_boards() + [self.get_public_board()]:
+            for player in board['players']:
+                if player['is_self']:
+                    self.assertEqual(len(player['tiles']), 12)
+                    continue
+                if words_visible:
+                    self.assertEqual(len(player['tiles']), 12)
+                    self.assertTrue(player['has_word'])
+                    self.assertTrue(len(player['word']) > 0)
+                else:
+                    self.assertEqual(player['letters'], '')
+                    self.assertEqual(player['tiles'], [])
+                    self.assertEqual(player['word'], '')
+            if cards_revealed:
+                continue
+            for card in board['cards']:
+                if card.get('is_self') or card.get('score') is not None:
+                    continue
+                self.assertEqual(card['player_id'], None)
+
+    def test_visibility_submit_word(self):
+        self.test_game_start()
+        self.assert_visible_fields(False)
+        p = '/games/%s/players/%s' % (self.game_token, self.player_token[0])
+        j = requests.get(self.api(p)).json()
+        word = self.fixed_word(j)
+        r = requests.put(self.api(p + '/word'), json={'word': word})
+        self.assertEqual(r.status_code, 200)
+        self.assert_visible_fields(False)
+        # Others only learn that the host's word is ready:
+        for board in self.get_boards() + [self.get_public_board()]:
+            host = board['players'][0]
+            self.assertTrue(host['has_word'])
+            self.assertEqual(host['word'], word if host['is_self'] else '')
+            self.assertFalse(board['players'][1]['has_word'])
+
+    def test_visibility_assign_words(self):
+        self.test_submit_word()
+        self.assert_visible_fields(True)
+        # Other players' guesses are not part of any response yet:
+        for board in self.get_boards() + [self.get_public_board()]:
+            self.assertFalse(board['currently_scored']['guesses'])
+
+    def test_visibility_score(self):
+        self.test_assign_all_words()
+        self.assert_visible_fields(True)
+
+    def test_visibility_between_rounds(self):
+        self.test_score()
+        self.assert_visible_fields(True, cards_revealed=True)
+
+    def test_concurrent_ready(self):
+        self.test_player_join()
+        status_codes = self.concurrently([
+            ('PUT', '/games/%s/players/%s/ready' % (self.game_token, player_token), None)
+            for player_token in self.player_token.values()
+            for _ in range(3)
+        ])
+        self.assertEqual(set(status_codes), {200, 403})
+        for j in self.get_boards():
+            self.assertEqual(j['phase'], 'submit-word')
+            self.assertEqual(j['round'], 1)
+            # Cards must only be dealt once:
+            self.assertEqual(len(j['cards']), 6)
+
+    def test_concurrent_submit_word(self):
+        self.test_game_start()
+        requests_ = []
+        for j, player_token in zip(self.get_boards(), self.player_token.values()):
+            p = '/games/%s/players/%s/word' % (self.game_token, player_token)
+            requests_.append(('PUT', p, {'word': self.fixed_word(j)}))
+        self.assertEqual(self.concurrently(requests_), [200, 200, 200])
+        for j in self.get_boards():
+            self.assertEqual(j['phase'], 'assign-words')
+
+    def test_concurrent_scored(self):
+        self.test_assign_all_words()
+        for attempt in range(10):
+            status_codes = self.concurrently([
+                ('PUT', '/games/%s/players/%s/scored' % (self.game_token, player_token), None)
+                for player_token in self.player_token.values()
+                for _ in range(2)
+            ])
+            self.assertTrue(set(status_codes) <= {200, 403})
+            if self.get_boards()[0]['phase'] == 'wait-for-ready':
+                break
+        for j in self.get_boards():
+            self.assertEqual(j['phase'], 'wait-for-ready')
+            # The round must only be increased once:
+            self.assertEqual(j['round'], 2)
+            revealed_cards = [c for c in j['cards'] if c.get('player_id')]
+            self.assertEqual(len(revealed_cards), 3)
+
+        status_codes = self.concurrently([
+            ('PUT', '/games/%s/players/%s/ready' % (self.game_token, player_token), None)
+            for player_token in self.player_token.values()
+        ])
+        self.assertEqual(status_codes, [200, 200, 200])
+        for j in self.get_boards():
+            self.assertEqual(j['phase'], 'submit-word')
+            self.assertEqual(j['round'], 2)
+            self.assertEqual(len(j['cards']), 6)
+
+    def open_websocket(self):
+        ws = WebSocket('/api/games/%s/ws' % self.game_token)
+        self.assertEqual(ws.status_code, 101)
+        self.addCleanup(ws.sock.close)
+        return ws
+
+    def test_websocket(self):
+        self.test_player_join()
+        ws = self.open_websocket()
+        # Without a player, the game-wide state is sent:
+        for name in ('players', 'scoreboard', 'public_board'):
+            self.assertEqual(ws.receive()['event'], name)
+
+        ws.send({'id': 1, 'type': 'ready'})
+        self.assertEqual(ws.receive_result(1)['status'], 401)
+        ws.send_frame(0x1, b'{')
+        self.assertEqual(ws.receive()['status'], 400)
+        ws.send({'id': 2, 'type': 'auth', 'player_token': 'invalid'})
+        self.assertEqual(ws.receive_result(2)['status'], 404)
+
+        ws.send({'id': 3, 'type': 'auth', 'player_token': self.player_token[0]})
+        # Switching to the player sends the player's board:
+        board = ws.receive_event('board')['data']
+        self.assertEqual(board['players'][0]['is_self'], True)
+        self.assertEqual(board['self']['is_ready'], False)
+        self.assertEqual(ws.receive_result(3)['status'], 200)
+
+        ws.send({'id': 4, 'type': 'ready'})
+        self.assertEqual(ws.receive_result(4)['status'], 200)
+        board = ws.receive_event('board')['data']
+        self.assertEqual(board['self']['is_ready'], True)
+        ws.send({'id': 5, 'type': 'unknown'})
+        self.assertEqual(ws.receive_result(5)['status'], 400)
+
+        # The server answers the close handshake and ends the connection:
+        self.assertIsNone(ws.close())
+
+    def count_goroutines(self, function):
+        r = requests.get('%s/debug/pprof/goroutine?debug=2' % SERVER)
+        if r.status_code != 200:
+            self.skipTest('server without -debug')
+        return r.text.count('.%s(' % function)
+
+    def test_websocket_close(self):
+        self.test_player_join()
+        # Connections which go away with commands in flight must not leave
+        # their reader behind:
+        for x in range(20):
+            ws = self.open_websocket()
+            for command_id in range(20):
+                ws.send({'id': command_id, 'type': 'ready'})
+            ws.sock.close()
+        for attempt in range(50):
+            if self.count_goroutines('readCommands') == 0:
+                break
+            time.sleep(0.1)
+        self.assertEqual(self.count_goroutines('readCommands'), 0)
+
+
+
+class TestSessionCookies(unittest.TestCase):
+    """Needs a server running with -sessionCookies, see make test-cookies."""
+
+    def api(self, path):
+        return '%s/api%s' % (SERVER, path)
+
+    def new_game(self, options={}):
+        s = requests.Session()
+        r = s.post(self.api('/games'), json=dict(NO_WORD_RULES, player_name='Player 1', **options))
+        self.assertEqual(r.status_code, 201)
+        if 'player_token' in r.json():
+            self.skipTest('server runs without -sessionCookies')
+        return s, r
+
+    def join(self, game_token, name):
+        s = requests.Session()
+        r = s.post(self.api('/games/%s/players' % game_token), json={'player_name': name})
+        self.assertEqual(r.status_code, 201)
+        self.assertNotIn('player_token', r.json())
+        return s, r
+
+    def self_path(self, game_token, path=''):
+        return self.api('/games/%s/self%s' % (game_token, path))
+
+    def test_cookie_path(self):
+        s, r = self.new_game()
+        game_token = r.json()['game_token']
+        value = r.cookies[SESSION_COOKIE]
+        cookie = r.headers['Set-Cookie']
+        self.assertIn('%s=' % SESSION_COOKIE, cookie)
+        self.assertIn('Path=/api/games/%s;' % game_token, cookie)
+        self.assertIn('HttpOnly', cookie)
+        self.assertIn('SameSite=Strict', cookie)
+        r = s.get(self.self_path(game_token))
+        self.assertEqual(r.status_code, 200)
+
+        # The cookie is neither sent to nor accepted by other games:
+        s2, r = self.new_game()
+        other_token = r.json()['game_token']
+        r = s.get(self.self_path(other_token))
+        self.assertEqual(r.status_code, 401)
+        r = requests.get(self.self_path(other_token), cookies={SESSION_COOKIE: value})
+        self.assertEqual(r.status_code, 401)
+        r = s2.get(self.self_path(other_token))
+        self.assertEqual(r.status_code, 200)
+
+    def test_cookie_signature(self):
+        _, r = self.new_game()
+        game_token = r.json()['game_token']
+        player_token, signature = r.cookies[SESSION_COOKIE].split('.', 1)
+        _, r = self.join(game_token, 'Player 2')
+        other_token = r.cookies[SESSION_COOKIE].split('.', 1)[0]
+
+        def get_self(value):
+            return requests.get(self.self_path(game_token), cookies={SESSION_COOKIE: value})
+        r = get_self('%s.%s' % (player_token, signature))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual([p['name'] for p in r.json()['players'] if p['is_self']], ['Player 1'])
+        tampered = signature[:-1] + ('A' if signature[-1] != 'A' else 'B')
+        for value in (player_token, player_token + '.', '.' + signature,
+                      '%s.%s' % (player_token, tampered),
+                      '%s.%s' % (other_token, signature)):
+            r = get_self(value)
+            self.assertEqual(r.status_code, 401, value)
+            self.assertEqual(r.json()['error'], 'missing or invalid session')
+
+        # Players with a token in the URL are moved over to a cookie:
+        r = requests.get(self.api('/games/%s/players/%s' % (game_token, player_token)))
+        self.assertEqual(r.status_code, 200)
+        self.assertEqual(r.cookies[SESSION_COOKIE], '%s.%s' % (player_token, signature))
+
+    def test_self_routes(self):
+        s, r = self.new_game()
+        game_token = r.json()['game_token']
+        sessions = [s]
+        for x in (2, 3):
+            sessions.append(self.join(game_token, 'Player %d' % x)[0])
+        for path in ('', '/events', '/guesses'):
+            r = requests.get(self.self_path(game_token, path))
+            self.assertEqual(r.status_code, 401)
+
+        r = sessions[1].patch(self.self_path(game_token, '/settings'), json={'max_rounds': 1})
+        self.assertEqual(r.status_code, 403)
+        r = s.patch(self.self_path(game_token, '/settings'), json={'max_rounds': 1})
+        self.assertEqual(r.status_code, 200)
+        r = s.post(self.api('/profiles'), json={'profile_name': 'Profile 1'})
+        self.assertEqual(r.status_code, 201)
+        r = s.put(self.self_path(game_token, '/profile'))
+        self.assertEqual(r.status_code, 200)
+
+        for s in sessions:
+            r = s.put(self.self_path(game_token, '/ready'))
+            self.assertEqual(r.status_code, 200)
+        r = sessions[0].put(self.self_path(game_token, '/mulligan'))
+        self.assertEqual(r.status_code, 200)
+
+        for s in sessions:
+            board = s.get(self.self_path(game_token)).json()
+            self.assertEqual(board['phase'], 'submit-word')
+            tiles = sorted((t for t in board['self']['tiles'] if t != '*'), key=lambda t: t == '\u00a0')
+            word = ''.join(tiles[:3])
+            r = s.put(self.self_path(game_token, '/word'), json={'word': word})
+            self.assertEqual(r.status_code, 200)
+            self.assertEqual(s.get(self.self_path(game_token)).json()['self']['word'], word)
+
+        for x, s in enumerate(sessions):
+            board = s.get(self.self_path(game_token)).json()
+            self.assertEqual(board['phase'], 'assign-words')
+            card_ids = [card['id'] for card in board['cards'] if not card['is_self']]
+            guesses = {str(p['id']): card_ids.pop(0) for p in board['players'] if not p['is_self']}
+            r = s.put(self.self_path(game_token, '/guesses'), json={'guesses': guesses})
+            self.assertEqual(r.status_code, 200)
+            if x == 0:
+                r = s.get(self.self_path(game_token, '/guesses'))
+                self.assertEqual(r.status_code, 200)
+                self.assertEqual(r.json()['guesses'], guesses)
 
+        board = sessions[0].get(self.self_path(game_token)).json()
+        while board['phase'] == 'score':
+            for s in sessions:
+                board = s.get(self.self_path(game_token)).json()
+                if board['phase'] != 'score':
+                    break
+                me = [p['id'] for p in board['players'] if p['is_self']][0]
+                r = s.put(self.self_path(game_token, '/scored'))
+                self.assertEqual(r.status_code, 200 if board['currently_scored']['player_id'] == me else 403)
+            board = sessions[0].get(self.self_path(game_token)).json()
+        self.assertEqual(board['phase'], 'game-over')
+        self.assertEqual(board['self']['mulligans_left'], 0)
 
 if __name__ == '__main__':
     sys.stdout.write("Waiting for webserver to become responsive")
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// TILE_* are the categories of tiles. Spaces and jokers are added
+	// by the game's settings and are not part of the tile sets.
+	TILE_VOWEL     = "vowel"
+	TILE_CONSONANT = "consonant"
+	// TILE_MAX_DUPLICATES is how often a tile may be dealt to one
+	// player, unless the tile set says otherwise.
+	TILE_MAX_DUPLICATES = 2
+	// TILE_SEPARATOR separates the tiles stored in Word.Letters.
+	TILE_SEPARATOR = " "
+)
+
+// Tile is a piece of a word which can be dealt, e.g. "A" or "SCH".
+type Tile struct {
+	Text string
+	// Weight is the relative frequency of the tile within its category.
+	Weight        int
+	Category      string
+	MaxDuplicates int
+}
+
+// TileSet lists the tiles of a language.
+type TileSet []Tile
+
+// tileSets holds the tile set of every language in gameLanguages.
+var tileSets = make(map[string]TileSet)
+
+// loadTileSets reads <tilesPath>/<language>.txt for every game language.
+func loadTileSets() error {
+	for language := range gameLanguages {
+		set, err := readTileSet(filepath.Join(tilesPath, language+".txt"))
+		if err != nil {
+			return err
+		}
+		tileSets[language] = set
+	}
+	return nil
+}
+
+// readTileSet parses a tile set file. Every line holds the tile's text,
+// weight, category and optionally the maximum number of duplicates,
+// separated by tabs. Empty lines and lines starting with # are ignored.
+func readTileSet(path string) (TileSet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var set TileSet
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s:%d: expected 3 or 4 fields", path, n)
+		}
+		tile := Tile{
+			Text:          fields[0],
+			Category:      fields[2],
+			MaxDuplicates: TILE_MAX_DUPLICATES,
+		}
+		if tile.Text == "" || seen[tile.Text] || strings.Contains(tile.Text, TILE_SEPARATOR) || strings.ContainsRune(tile.Text, JOKER) {
+			return nil, fmt.Errorf("%s:%d: invalid tile %q", path, n, tile.Text)
+		}
+		seen[tile.Text] = true
+		tile.Weight, err = strconv.Atoi(fields[1])
+		if err != nil || tile.Weight < 1 {
+			return nil, fmt.Errorf("%s:%d: invalid weight %q", path, n, fields[1])
+		}
+		if tile.Category != TILE_VOWEL && tile.Category != TILE_CONSONANT {
+			return nil, fmt.Errorf("%s:%d: invalid category %q", path, n, tile.Category)
+		}
+		if len(fields) == 4 {
+			tile.MaxDuplicates, err = strconv.Atoi(fields[3])
+			if err != nil || tile.MaxDuplicates < 1 {
+				return nil, fmt.Errorf("%s:%d: invalid maximum of duplicates %q", path, n, fields[3])
+			}
+		}
+		set = append(set, tile)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if set.capacity(TILE_VOWEL) < SETTINGS_MAX_VOCALS || set.capacity(TILE_CONSONANT) < SETTINGS_MAX_CONSONANTS {
+		return nil, fmt.Errorf("%s: not enough tiles", path)
+	}
+	return set, nil
+}
+
+// capacity is the number of tiles of the category which can be dealt to
+// one player.
+func (set TileSet) capacity(category string) int {
+	var n int
+	for _, tile := range set {
+		if tile.Category == category {
+			n += tile.MaxDuplicates
+		}
+	}
+	return n
+}
+
+// vowels returns the letters of all vowel tiles.
+func (set TileSet) vowels() string {
+	var vowels strings.Builder
+	for _, tile := range set {
+		if tile.Category == TILE_VOWEL {
+			vowels.WriteString(tile.Text)
+		}
+	}
+	return vowels.String()
+}
+
+// deal draws num tiles of the category according to their weights.
+// counts holds the tiles dealt so far and is updated, so that no tile is
+// dealt more often than allowed.
+func (set TileSet) deal(category string, num int, counts map[string]int) []string {
+	dealt := make([]string, 0, num)
+	for x := 0; x < num; x++ {
+		var total int
+		for _, tile := range set {
+			if tile.Category == category && counts[tile.Text] < tile.MaxDuplicates {
+				total += tile.Weight
+			}
+		}
+		if total == 0 {
+			break
+		}
+		r := rand.Intn(total)
+		for _, tile := range set {
+			if tile.Category != category || counts[tile.Text] >= tile.MaxDuplicates {
+				continue
+			}
+			if r < tile.Weight {
+				dealt = append(dealt, tile.Text)
+				counts[tile.Text]++
+				break
+			}
+			r -= tile.Weight
+		}
+	}
+	return dealt
+}
+
+func formatTiles(tiles []string) string {
+	return strings.Join(tiles, TILE_SEPARATOR)
+}
+
+// parseTiles splits Word.Letters into tiles. Letters which were dealt
+// before tile sets existed have no separators and one tile per rune.
+func parseTiles(letters string) []string {
+	if !strings.Contains(letters, TILE_SEPARATOR) {
+		tiles := make([]string, 0, len(letters))
+		for _, r := range letters {
+			tiles = append(tiles, string(r))
+		}
+		return tiles
+	}
+	return strings.Split(letters, TILE_SEPARATOR)
+}
+
+// matchTiles tells whether the word starting at pos can be laid with the
+// given tiles. Letters at the joker positions have to be laid with
+// jokers.
+func matchTiles(word []rune, pos int, isJoker map[int]bool, tiles []string) bool {
+	if pos == len(word) {
+		return true
+	}
+	tried := make(map[string]bool)
+	for i, tile := range tiles {
+		if tried[tile] || !tileMatches(word, pos, isJoker, tile) {
+			continue
+		}
+		tried[tile] = true
+		rest := append(append([]string{}, tiles[:i]...), tiles[i+1:]...)
+		if matchTiles(word, pos+len([]rune(tile)), isJoker, rest) {
+			return true
+		}
+	}
+	return false
+}
+
+func tileMatches(word []rune, pos int, isJoker map[int]bool, tile string) bool {
+	if isJoker[pos] {
+		return tile == string(JOKER)
+	}
+	runes := []rune(tile)
+	if tile == string(JOKER) || pos+len(runes) > len(word) {
+		return false
+	}
+	for i, r := range runes {
+		if isJoker[pos+i] || word[pos+i] != r {
+			return false
+		}
+	}
+	return true
+}
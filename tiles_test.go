@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadTileSet(t *testing.T) {
+	for language := range gameLanguages {
+		set, err := readTileSet("tiles/" + language + ".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tile := range set {
+			if n := len([]rune(tile.Text)); n > 3 {
+				t.Errorf("%s: tile %q has %d letters", language, tile.Text, n)
+			}
+		}
+
+		counts := make(map[string]int)
+		vowels := set.deal(TILE_VOWEL, SETTINGS_MAX_VOCALS, counts)
+		consonants := set.deal(TILE_CONSONANT, SETTINGS_MAX_CONSONANTS, counts)
+		if len(vowels) != SETTINGS_MAX_VOCALS || len(consonants) != SETTINGS_MAX_CONSONANTS {
+			t.Errorf("%s: dealt %d vowels and %d consonants", language, len(vowels), len(consonants))
+		}
+		for _, tile := range set {
+			if counts[tile.Text] > tile.MaxDuplicates {
+				t.Errorf("%s: tile %q was dealt %d times", language, tile.Text, counts[tile.Text])
+			}
+		}
+	}
+}
+
+func TestParseTiles(t *testing.T) {
+	tests := map[string][]string{
+		"":               {},
+		"ABC":            {"A", "B", "C"},
+		"ÄÖ*":            {"Ä", "Ö", "*"},
+		"SCH A \u00a0 *": {"SCH", "A", "\u00a0", "*"},
+	}
+	for letters, tiles := range tests {
+		if got := parseTiles(letters); !reflect.DeepEqual(got, tiles) {
+			t.Errorf("parseTiles(%q) = %q, want %q", letters, got, tiles)
+		}
+	}
+	tiles := []string{"SCH", "A", "*"}
+	if got := parseTiles(formatTiles(tiles)); !reflect.DeepEqual(got, tiles) {
+		t.Errorf("parseTiles(formatTiles(%q)) = %q", tiles, got)
+	}
+}
+
+func TestMatchTiles(t *testing.T) {
+	tiles := []string{"SCH", "A", "L", "CH", "E", "*"}
+	tests := []struct {
+		word   string
+		jokers []int
+		ok     bool
+	}{
+		{"SCHAL", nil, true},
+		{"LACHE", nil, true},
+		{"ACH", nil, true},
+		{"SCHACHE", nil, true},
+		{"SCHACHA", nil, false},
+		// Multi-letter tiles can't be split:
+		{"SA", nil, false},
+		{"CHS", nil, false},
+		{"LAX", []int{2}, true},
+		{"LAXY", []int{2, 3}, false},
+		// A joker can't be replaced by a regular tile:
+		{"LAE", []int{2}, true},
+		{"SCHAL", []int{1}, false},
+	}
+	for _, test := range tests {
+		isJoker := make(map[int]bool)
+		for _, pos := range test.jokers {
+			isJoker[pos] = true
+		}
+		if ok := matchTiles([]rune(test.word), 0, isJoker, tiles); ok != test.ok {
+			t.Errorf("matchTiles(%q, %v) = %v, want %v", test.word, test.jokers, ok, test.ok)
+		}
+	}
+}
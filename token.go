@@ -1,11 +1,13 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
 )
 
+// generateToken returns a random hex token. Tokens are the only thing
+// protecting games and player seats, so they have to be unpredictable.
 func generateToken() string {
 	b := make([]byte, 12)
 	if _, err := rand.Read(b); err != nil {
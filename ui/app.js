@@ -5,7 +5,7 @@ async function GET(url = '') {
       method: 'GET',
       mode: 'same-origin',
       cache: 'no-cache',
-      credentials: 'omit',
+      credentials: 'same-origin',
       referrerPolicy: 'no-referrer',
     });
     return await response;
@@ -19,7 +19,7 @@ async function POST(url = '', data = {}) {
       method: 'POST',
       mode: 'same-origin',
       cache: 'no-cache',
-      credentials: 'omit',
+      credentials: 'same-origin',
       headers: {
         'Content-Type': 'application/json'
       },
@@ -37,7 +37,7 @@ async function PUT(url = '', data = {}) {
       method: 'PUT',
       mode: 'same-origin',
       cache: 'no-cache',
-      credentials: 'omit',
+      credentials: 'same-origin',
       headers: {
         'Content-Type': 'application/json'
       },
@@ -60,12 +60,32 @@ async function handleError(f) {
   if (!response.ok) {
     App.log("error", "API http status: " + response.status);
     console.log(response);
-    throw "api call failed";
+    // Rejected actions tell why, see respondActionError:
+    var body = await response.json().catch(function() { return null; });
+    throw (body && body.error) ? body : {error: "api call failed"};
   }
 
   return response.json();
 }
 
+// wordErrors explain why a word was rejected, keyed by the WORD_ERROR_*
+// codes.
+const wordErrors = {
+  'word_too_short': 'Das Wort ist zu kurz.',
+  'word_without_vowel': 'Das Wort braucht mindestens einen Vokal.',
+  'word_from_card_text': 'Das Wort darf nicht aus dem Text der Karte stammen.',
+  'word_from_dictionary': 'Das Wort darf kein echtes Wort sein.',
+};
+
+// playerAPI returns the API base path for the current player, which is
+// either identified by the URL token or by the session cookie.
+function playerAPI(route) {
+  if (!route.params.player_token) {
+    return '/api/games/' + route.params.game_token + '/self';
+  }
+  return '/api/games/' + route.params.game_token + '/players/' + route.params.player_token;
+}
+
 const NewGame = {
   template: '#new-game-template',
   data: function() {
@@ -79,6 +99,16 @@ const NewGame = {
       POST('/api/games', {
         'player_name': this.playerName
       }).then((d) => {
+        if (!d.player_token) {
+          // Session cookie mode, the player token is not part of the URL:
+          this.$router.push({
+            'name': 'Play',
+            'params': {
+              'game_token': d.game_token,
+            },
+          });
+          return;
+        }
         this.$router.push({
           'name': 'Board',
           'params': {
@@ -110,6 +140,16 @@ const JoinGame = {
       POST('/api/games/' + this.$route.params.game_token + '/players', {
         'player_name': this.playerName,
       }).then((d) => {
+        if (!d || !d.player_token) {
+          // Session cookie mode, the player token is not part of the URL:
+          this.$router.push({
+            'name': 'Play',
+            'params': {
+              'game_token': this.$route.params.game_token,
+            },
+          });
+          return;
+        }
         this.$router.push({
           'name': 'Board',
           'params': {
@@ -135,6 +175,9 @@ const JoinGame = {
       App.addEventListener('players', this.fetch);
     });
   },
+  beforeDestroy: function() {
+    App.removeEventListener('players', this.fetch);
+  },
 }
 
 Vue.component('player', {
@@ -158,7 +201,7 @@ Vue.component('player', {
   },
   methods: {
     markReady: function(event) {
-      PUT('/api/games/' + this.$route.params.game_token + '/players/' + this.$route.params.player_token + '/ready', {});
+      PUT(playerAPI(this.$route) + '/ready', {});
     },
     onCardAdd: function(event) {
       this.$emit('guess-word', {
@@ -202,9 +245,9 @@ Vue.component('middle-card', {
   props: ['text', 'hover', 'score'],
 });
 
-const Board = {
-  template: '#board-template',
-  props: ['scoreboardButtonPressed'],
+// boardLookup is shared by all components which show a board, either a
+// player's one or the public one.
+const boardLookup = {
   computed: {
     currently_scored_player: function() {
       var player_id = this.board.currently_scored.player_id;
@@ -235,12 +278,39 @@ const Board = {
       return cards_with_players;
     },
   },
+  methods: {
+    getCard: function(id) {
+      for (var i = 0; i < this.board.cards.length; i++) {
+        var card = this.board.cards[i];
+        if (card.id == id) {
+          return card;
+        }
+      }
+    },
+    getPlayer: function(id) {
+      // TODO performance: should be map-based lookup
+      for (var i = 0; i < this.board.players.length; i++) {
+        var player = this.board.players[i];
+        if (player.id == id) {
+          return player;
+        }
+      }
+    },
+  },
+}
+
+const Board = {
+  template: '#board-template',
+  props: ['scoreboardButtonPressed'],
+  mixins: [boardLookup],
   data: function() {
     return {
       'baseURL': window.location.protocol + '//' + window.location.host,
       'board': {
+        'version': 0,
         'self': {
           'letters': '',
+          'tiles': [],
         },
         'cards': [],
         'players': [],
@@ -255,26 +325,11 @@ const Board = {
       'scoreboard': [],
       'scoreboardVisible': false,
       'helpSnackbarsEnabled': true,
+      'mulligan_player': null,
+      'word_error': null,
     }
   },
   methods: {
-    getCard: function(id) {
-      for (var i = 0; i < this.board.cards.length; i++) {
-        var card = this.board.cards[i];
-        if (card.id == id) {
-          return card;
-        }
-      }
-    },
-    getPlayer: function(id) {
-      // TODO performance: should be map-based lookup
-      for (var i = 0; i < this.board.players.length; i++) {
-        var player = this.board.players[i];
-        if (player.id == id) {
-          return player;
-        }
-      }
-    },
     updateScoreboard: function() {
       var new_scoreboard = [];
       for (var i = 0; i < this.board.scoreboard_order.length; i++) {
@@ -289,80 +344,136 @@ const Board = {
       }
       this.scoreboard = new_scoreboard;
     },
+    onBoardEvent: function(event) {
+      if (!event || !event.data) {
+        this.fetch();
+        return;
+      }
+      this.applyBoard(JSON.parse(event.data));
+    },
     fetch: function() {
-      if (!this.$route.params.player_token) return;
-      GET('/api/games/' + this.$route.params.game_token + '/players/' + this.$route.params.player_token).then((d) => {
-        if (d.phase == 'submit-word' && this.board.phase != 'submit-word') {
-          this.word_letters = d.self.word.split('');
-          this.letters = d.self.letters.split('');
-          this.num_guesses = 0;
-          this.guesses_saved = false;
-        }
+      GET(playerAPI(this.$route)).then(this.applyBoard);
+    },
+    applyBoard: function(d) {
+      if (d.version < this.board.version) {
+        // Outdated board, a newer one has already been applied.
+        return;
+      }
+      var redrawn = d.phase == 'submit-word' && this.board.phase == 'submit-word' && d.self.letters != this.board.self.letters;
+      if ((d.phase == 'submit-word' && this.board.phase != 'submit-word') || redrawn) {
+        this.word_letters = d.self.word.split('').map(function(letter, index) {
+          // Jokers are kept along with the letter they stand for:
+          return d.self.jokers.indexOf(index) != -1 ? '*' + letter : letter;
+        });
+        this.letters = d.self.tiles.slice();
+        this.num_guesses = 0;
+        this.guesses_saved = false;
+      }
 
-        var animTime = 0;
-        if (this.board.phase == 'score' && this.show_currently_scored) {
-          // longer animation
-          animTime = 2000;
-        }
-        // must be called before the board is replaced:
-        if (this.board.phase == 'score' && d.phase != 'score') {
-          this.show_currently_scored = false;
-          // wait for animation to finish, then switch board,
-          // update scoreboard and display it.
-          window.setTimeout(function() {
-            this.board = d;
-            this.updateScoreboard();
-            this.scoreboardVisible = true;
-          }.bind(this), animTime);
-          // end early to avoid updating the board here:
-          return;
-        }
+      var animTime = 0;
+      if (this.board.phase == 'score' && this.show_currently_scored) {
+        // longer animation
+        animTime = 2000;
+      }
+      // must be called before the board is replaced:
+      if (this.board.phase == 'score' && d.phase != 'score') {
+        this.show_currently_scored = false;
+        // wait for animation to finish, then switch board,
+        // update scoreboard and display it.
+        window.setTimeout(function() {
+          if (d.version < this.board.version) return;
+          this.board = d;
+          this.updateScoreboard();
+          this.scoreboardVisible = true;
+        }.bind(this), animTime);
+        // end early to avoid updating the board here:
+        return;
+      }
 
-        if (this.board.currently_scored != d.currently_scored) {
-          this.show_currently_scored = false;
-          window.setTimeout(function() {
-            this.show_currently_scored = true;
-          }.bind(this), animTime);
-        }
+      if (this.board.currently_scored != d.currently_scored) {
+        this.show_currently_scored = false;
+        window.setTimeout(function() {
+          this.show_currently_scored = true;
+        }.bind(this), animTime);
+      }
 
-        this.board = d;
+      this.board = d;
 
-        // must be called after the new board is set:
-        this.updateScoreboard();
+      // must be called after the new board is set:
+      this.updateScoreboard();
 
-        if (this.board.phase == 'assign-words') {
-          GET('/api/games/' + this.$route.params.game_token + '/players/' + this.$route.params.player_token + '/guesses').then((g) => {
-            if (this.num_guesses != 0) {
-              // Do not overwrite unsaved guesses / only set guesses on initial load.
-              return;
-            }
-            this.guesses = g['guesses'];
-            var guessed_card_ids = [];
-            for (var player_id in this.guesses) {
-              guessed_card_ids.push(this.guesses[player_id]);
-              this.num_guesses++;
-              this.guesses_saved = true;
-            }
-            var new_middle_cards = [];
-            for (var i = 0; i < this.board.cards.length; i++) {
-              var card = this.board.cards[i];
-              if (guessed_card_ids.indexOf(card.id) === -1) {
-                new_middle_cards.push(card);
-              }
+      if (this.board.phase == 'assign-words') {
+        GET(playerAPI(this.$route) + '/guesses').then((g) => {
+          if (this.num_guesses != 0) {
+            // Do not overwrite unsaved guesses / only set guesses on initial load.
+            return;
+          }
+          this.guesses = g['guesses'];
+          var guessed_card_ids = [];
+          for (var player_id in this.guesses) {
+            guessed_card_ids.push(this.guesses[player_id]);
+            this.num_guesses++;
+            this.guesses_saved = true;
+          }
+          var new_middle_cards = [];
+          for (var i = 0; i < this.board.cards.length; i++) {
+            var card = this.board.cards[i];
+            if (guessed_card_ids.indexOf(card.id) === -1) {
+              new_middle_cards.push(card);
             }
-            this.middle_cards = new_middle_cards;
-          });
-        }
-      });
+          }
+          this.middle_cards = new_middle_cards;
+        });
+      }
+    },
+    chooseJokerLetter: function(index) {
+      if (this.word_letters[index][0] != '*') return;
+      var letter = window.prompt('Für welchen Buchstaben steht der Joker?');
+      if (!letter) return;
+      letter = Array.from(letter.trim())[0];
+      if (!letter) return;
+      // Some letters have no single upper case letter, e.g. ß becomes SS:
+      var upper = letter.toUpperCase();
+      if (Array.from(upper).length == 1) letter = upper;
+      this.word_letters.splice(index, 1, '*' + letter);
     },
     submitWord: function() {
       if (!this.word_letters.length) return;
-      PUT('/api/games/' + this.$route.params.game_token + '/players/' + this.$route.params.player_token + '/word', {
-        word: this.word_letters.join(''),
+      var word = '';
+      var jokers = [];
+      for (var i = 0; i < this.word_letters.length; i++) {
+        var letter = this.word_letters[i];
+        if (letter[0] == '*') {
+          if (letter.length < 2) {
+            App.log('error', 'Wähle für jeden Joker einen Buchstaben.');
+            return;
+          }
+          // Joker positions are counted in letters, not in tiles:
+          jokers.push(Array.from(word).length);
+          letter = letter.substr(1);
+        }
+        word += letter;
+      }
+      var self = this;
+      PUT(playerAPI(this.$route) + '/word', {
+        word: word,
+        jokers: jokers,
+      }).catch(function(e) {
+        self.word_error = wordErrors[e.code] || null;
       });
     },
+    takeMulligan: function() {
+      PUT(playerAPI(this.$route) + '/mulligan');
+    },
+    onMulliganEvent: function(event) {
+      if (!event || !event.data) return;
+      var d = JSON.parse(event.data);
+      var player = this.getPlayer(d.player_id);
+      if (!player || player.is_self) return;
+      this.mulligan_player = d.name;
+    },
     markScored: function() {
-      PUT('/api/games/' + this.$route.params.game_token + '/players/' + this.$route.params.player_token + '/scored');
+      PUT(playerAPI(this.$route) + '/scored');
     },
     onGuess: function(guess) {
       this.guesses[guess.player_id] = guess.card_id;
@@ -373,18 +484,22 @@ const Board = {
       }
     },
     submitGuesses: function() {
-      PUT('/api/games/' + this.$route.params.game_token + '/players/' + this.$route.params.player_token + '/guesses', {guesses: this.guesses}).then((d) => {
+      PUT(playerAPI(this.$route) + '/guesses', {guesses: this.guesses}).then((d) => {
         this.guesses_saved = true;
       });
     },
   },
   mounted: function() {
     this.$nextTick(function() {
-      App.setupEventSource(this.$route.params.game_token);
-      App.addEventListener('players', this.fetch);
-      App.addEventListener('board', this.fetch);
+      App.setupEventSource(this.$route);
+      App.addEventListener('board', this.onBoardEvent);
+      App.addEventListener('mulligan', this.onMulliganEvent);
     });
   },
+  beforeDestroy: function() {
+    App.removeEventListener('board', this.onBoardEvent);
+    App.removeEventListener('mulligan', this.onMulliganEvent);
+  },
   watch: {
     'scoreboardVisible': function() {
       if (!this.scoreboardVisible) {
@@ -399,10 +514,75 @@ const Board = {
   },
 }
 
+// TV shows the public board on a shared screen, e.g. when playing in the
+// same room. It is read-only and never shows a player's own view.
+const TV = {
+  template: '#tv-template',
+  mixins: [boardLookup],
+  data: function() {
+    return {
+      'board': {
+        'version': 0,
+        'cards': [],
+        'players': [],
+        'scoreboard_order': [],
+        'currently_scored': {},
+      },
+      'invitation': null,
+      'qrCodeURL': '/api/games/' + this.$route.params.game_token + '/invitation/qr.svg',
+    }
+  },
+  computed: {
+    scoreboard: function() {
+      var scoreboard = [];
+      for (var i = 0; i < this.board.scoreboard_order.length; i++) {
+        var player = this.getPlayer(this.board.scoreboard_order[i]);
+        if (player) scoreboard.push(player);
+      }
+      return scoreboard;
+    },
+  },
+  methods: {
+    onBoardEvent: function(event) {
+      if (!event || !event.data) {
+        this.fetch();
+        return;
+      }
+      this.applyBoard(JSON.parse(event.data));
+    },
+    fetch: function() {
+      GET('/api/games/' + this.$route.params.game_token + '/board').then(this.applyBoard);
+    },
+    applyBoard: function(d) {
+      if (d.version < this.board.version) {
+        // Outdated board, a newer one has already been applied.
+        return;
+      }
+      this.board = d;
+      if (this.board.phase == 'wait-for-ready' && this.board.round <= 1 && !this.invitation) {
+        GET('/api/games/' + this.$route.params.game_token + '/invitation').then((i) => {
+          this.invitation = i;
+        });
+      }
+    },
+  },
+  mounted: function() {
+    this.$nextTick(function() {
+      App.setupEventSource(this.$route);
+      App.addEventListener('public_board', this.onBoardEvent);
+    });
+  },
+  beforeDestroy: function() {
+    App.removeEventListener('public_board', this.onBoardEvent);
+  },
+}
+
 const routes = [
   { name: 'NewGame', path: '/', component: NewGame },
   { name: 'JoinGame', path: '/games/:game_token', component: JoinGame },
-  { name: 'Board', path: '/games/:game_token/players/:player_token', component: Board }
+  { name: 'Board', path: '/games/:game_token/players/:player_token', component: Board },
+  { name: 'Play', path: '/games/:game_token/play', component: Board },
+  { name: 'TV', path: '/games/:game_token/tv', component: TV }
 ]
 
 const router = new VueRouter({
@@ -412,14 +592,41 @@ const router = new VueRouter({
 
 var App = {
   eventSource: null,
-  game_token: null,
+  eventSourceURL: null,
+  lastEventID: null,
   eventSourceReconnectTime: 0.2,
-  setupEventSource: function(game_token) {
-    if (!game_token) return;
-    if (App.eventSource && App.game_token == game_token) return;
-    App.game_token = game_token;
-    App.eventSource = new EventSource('/api/games/' + game_token + '/events');
+  setupEventSource: function(route) {
+    if (!route.params.game_token) return;
+    var url = '/api/games/' + route.params.game_token + '/events';
+    if (route.name == 'Board' || route.name == 'Play') {
+      // Players get their own stream which carries their board:
+      url = playerAPI(route) + '/events';
+    }
+    App.connectEventSource(url);
+  },
+  connectEventSource: function(url) {
+    if (App.eventSource && App.eventSourceURL == url) return;
+    if (App.eventSource) {
+      App.eventSource.close();
+      App.eventListenerRequestsIdx = 0;
+    }
+    if (App.eventSourceURL != url) {
+      App.lastEventID = null;
+    }
+    App.eventSourceURL = url;
+    var resumeURL = url;
+    if (App.lastEventID) {
+      // A new EventSource doesn't send Last-Event-ID by itself:
+      resumeURL += '?last_event_id=' + encodeURIComponent(App.lastEventID);
+    }
+    App.eventSource = new EventSource(resumeURL);
     App.eventSource.onerror = function(err) {
+      if (App.eventSource.readyState == EventSource.CONNECTING) {
+        // The browser reconnects by itself, honoring the server's
+        // retry interval and resuming from the last event id.
+        console.log("eventSource interrupted, reconnecting:", err);
+        return;
+      }
       App.eventSource.close();
       console.log("eventSource failed:", err);
       App.eventSource = null;
@@ -429,8 +636,9 @@ var App = {
       }
       console.log("scheduling eventSource reconnect in seconds:", App.eventSourceReconnectTime);
       window.setTimeout(function() {
+        if (App.eventSource || App.eventSourceURL != url) return;
         console.log("reconnecting eventSource");
-        App.setupEventSource(game_token);
+        App.connectEventSource(url);
       }, App.eventSourceReconnectTime*1000);
     };
     App.eventSource.onopen = function() {
@@ -444,11 +652,29 @@ var App = {
     App.eventListenerRequests.push([event, callback]);
     App.runEventListenerRequests();
   },
+  removeEventListener: function(event, callback) {
+    for (var i = 0; i < App.eventListenerRequests.length; i++) {
+      var e = App.eventListenerRequests[i];
+      if (e[0] != event || e[1] != callback) continue;
+      App.eventListenerRequests.splice(i, 1);
+      if (i < App.eventListenerRequestsIdx) {
+        App.eventListenerRequestsIdx--;
+        if (App.eventSource) App.eventSource.removeEventListener(event, callback);
+      }
+      return;
+    }
+  },
+  recordEventID: function(event) {
+    if (event.lastEventId) {
+      App.lastEventID = event.lastEventId;
+    }
+  },
   runEventListenerRequests: function() {
     if (!App.eventSource) return;
     for (; App.eventListenerRequestsIdx < App.eventListenerRequests.length; App.eventListenerRequestsIdx++) {
       var e = App.eventListenerRequests[App.eventListenerRequestsIdx];
       App.eventSource.addEventListener(e[0], e[1]);
+      App.eventSource.addEventListener(e[0], App.recordEventID);
       console.log("registering and running eventSource listener", e);
       e[1]();
     }
@@ -476,11 +702,11 @@ const app = new Vue({
   },
   mounted: function() {
     App.log = this.log;
-    App.setupEventSource(this.$route.params.game_token);
+    App.setupEventSource(this.$route);
   },
   watch: {
     '$route': function(to, from) {
-      App.setupEventSource(this.$route.params.game_token);
+      App.setupEventSource(this.$route);
     },
   },
   methods: {
@@ -0,0 +1,92 @@
+package main
+
+// viewerRole tells how the viewer of a board is related to a player
+// shown on it.
+type viewerRole int
+
+const (
+	VIEWER_SELF viewerRole = iota
+	VIEWER_PLAYER
+	VIEWER_SPECTATOR
+)
+
+// playerFields are the parts of jsonPlayer which are not always public.
+type playerFields struct {
+	Letters bool
+	Word    bool
+}
+
+var (
+	allPlayerFields = playerFields{Letters: true, Word: true}
+	noPlayerFields  = playerFields{}
+)
+
+// playerVisibility decides per phase which fields of a player a viewer
+// may see. While words are being submitted, a player's letters and word
+// are only shown to that player, so that nobody can match them to cards
+// or adapt their own word early. Phases which are missing hide
+// everything.
+//
+// There is no role for the host: the host is a seated player, so seeing
+// more would be cheating, and a screen shared with the room is the TV
+// view, which gets the public board with VIEWER_SPECTATOR.
+var playerVisibility = map[string]map[viewerRole]playerFields{
+	GAME_PHASE_WAIT_FOR_READY: {
+		VIEWER_SELF:      allPlayerFields,
+		VIEWER_PLAYER:    allPlayerFields,
+		VIEWER_SPECTATOR: allPlayerFields,
+	},
+	GAME_PHASE_SUBMIT_WORD: {
+		VIEWER_SELF:      allPlayerFields,
+		VIEWER_PLAYER:    noPlayerFields,
+		VIEWER_SPECTATOR: noPlayerFields,
+	},
+	GAME_PHASE_ASSIGN_WORDS: {
+		VIEWER_SELF:      allPlayerFields,
+		VIEWER_PLAYER:    allPlayerFields,
+		VIEWER_SPECTATOR: allPlayerFields,
+	},
+	GAME_PHASE_SCORE: {
+		VIEWER_SELF:      allPlayerFields,
+		VIEWER_PLAYER:    allPlayerFields,
+		VIEWER_SPECTATOR: allPlayerFields,
+	},
+	GAME_PHASE_GAME_OVER: {
+		VIEWER_SELF:      allPlayerFields,
+		VIEWER_PLAYER:    allPlayerFields,
+		VIEWER_SPECTATOR: allPlayerFields,
+	},
+}
+
+// getViewerRole returns the role of the viewer when looking at a player
+// or at the card of a player. A viewerID of 0 stands for a spectator, a
+// playerID of 0 for nobody, e.g. the owner of a decoy card.
+func getViewerRole(viewerID uint64, playerID uint64) viewerRole {
+	switch viewerID {
+	case 0:
+		return VIEWER_SPECTATOR
+	case playerID:
+		return VIEWER_SELF
+	}
+	return VIEWER_PLAYER
+}
+
+// hidePlayerFields clears whatever the viewer may not see of the player
+// in the given phase.
+func hidePlayerFields(player *jsonPlayer, phase string, role viewerRole) {
+	fields := playerVisibility[phase][role]
+	if !fields.Letters {
+		player.Letters = ""
+		player.Tiles = make([]string, 0)
+	}
+	if !fields.Word {
+		player.Word = ""
+		player.Jokers = make([]int, 0)
+	}
+}
+
+// isCardOwnerVisible decides whether a card may be linked to the player
+// who got it. Only the player's own card is known before it is scored.
+func isCardOwnerVisible(role viewerRole, isScored bool) bool {
+	return role == VIEWER_SELF || isScored
+}
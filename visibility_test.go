@@ -0,0 +1,133 @@
+package main
+
+import (
+	"testing"
+)
+
+var allViewerRoles = []viewerRole{VIEWER_SELF, VIEWER_PLAYER, VIEWER_SPECTATOR}
+
+// testBoard returns the board as the player gets to see it.
+func testBoard(t *testing.T, game Game, player Player) jsonBoard {
+	err := db.First(&player, player.ID).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	player.Game = reloadTestGame(t, game)
+	board, err := getBoardJson(player)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return board
+}
+
+// checkHidden fails if anything of the player's word is shown.
+func checkHidden(t *testing.T, viewer string, p jsonPlayer) {
+	if p.Letters != "" || len(p.Tiles) != 0 || p.Word != "" || len(p.Jokers) != 0 {
+		t.Errorf("%s sees letters %q and word %q of player %d", viewer, p.Letters, p.Word, p.ID)
+	}
+}
+
+func TestBoardVisibility(t *testing.T) {
+	setupTestDB(t)
+	game, players := createTestGame(t)
+	for _, player := range players {
+		err := setPlayerReady(player)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	game = reloadTestGame(t, game)
+	submitTestWord(t, game, players[1])
+
+	// Only the own word is shown while words are being submitted:
+	for _, viewer := range players[:2] {
+		board := testBoard(t, game, viewer)
+		for _, p := range board.Players {
+			if p.ID == viewer.ID {
+				if p.Letters == "" || p.Letters != board.Self.Letters {
+					t.Errorf("player %d doesn't see their letters", viewer.ID)
+				}
+				continue
+			}
+			checkHidden(t, "another player", p)
+			if p.HasWord != (p.ID == players[1].ID) {
+				t.Errorf("player %d sees has_word %v of player %d", viewer.ID, p.HasWord, p.ID)
+			}
+		}
+		for _, card := range board.Cards {
+			if (card.PlayerID != nil) != card.IsSelf {
+				t.Errorf("player %d sees the owner of card %d", viewer.ID, card.ID)
+			}
+		}
+	}
+	public, err := getPublicBoardJson(reloadTestGame(t, game))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range public.Players {
+		checkHidden(t, "a spectator", p)
+	}
+	for _, card := range public.Cards {
+		if card.PlayerID != nil {
+			t.Errorf("a spectator sees the owner of card %d", card.ID)
+		}
+	}
+
+	// Once all words are there, everybody sees them, but cards stay
+	// anonymous until they are scored:
+	submitTestWord(t, game, players[0])
+	submitTestWord(t, game, players[2])
+	board := testBoard(t, game, players[0])
+	if board.Phase != GAME_PHASE_ASSIGN_WORDS {
+		t.Fatalf("game is in phase %s", board.Phase)
+	}
+	for _, p := range board.Players {
+		if p.Letters == "" || p.Word == "" {
+			t.Errorf("player %d can't see letters %q and word %q of player %d", players[0].ID, p.Letters, p.Word, p.ID)
+		}
+	}
+	for _, card := range board.Cards {
+		if (card.PlayerID != nil) != card.IsSelf {
+			t.Errorf("the owner of card %d is shown before scoring", card.ID)
+		}
+	}
+}
+
+func TestHidePlayerFieldsUnknownPhase(t *testing.T) {
+	p := jsonPlayer{Letters: "ABCDEFGHIJKL", Word: "ABC"}
+	hidePlayerFields(&p, "unknown", VIEWER_SELF)
+	if p.Letters != "" || p.Word != "" {
+		t.Errorf("unknown phases must hide everything, got letters %q and word %q", p.Letters, p.Word)
+	}
+}
+
+func TestViewerRole(t *testing.T) {
+	tests := []struct {
+		viewerID, playerID uint64
+		role               viewerRole
+	}{
+		{0, 1, VIEWER_SPECTATOR},
+		{0, 0, VIEWER_SPECTATOR},
+		{1, 1, VIEWER_SELF},
+		{1, 2, VIEWER_PLAYER},
+		// Decoy cards have no owner:
+		{1, 0, VIEWER_PLAYER},
+	}
+	for _, test := range tests {
+		role := getViewerRole(test.viewerID, test.playerID)
+		if role != test.role {
+			t.Errorf("getViewerRole(%d, %d) = %d, want %d", test.viewerID, test.playerID, role, test.role)
+		}
+	}
+}
+
+func TestCardOwnerVisibility(t *testing.T) {
+	for _, role := range allViewerRoles {
+		if !isCardOwnerVisible(role, true) {
+			t.Errorf("role %d can't see the owner of a scored card", role)
+		}
+		if isCardOwnerVisible(role, false) != (role == VIEWER_SELF) {
+			t.Errorf("role %d: wrong visibility of an unscored card's owner", role)
+		}
+	}
+}
@@ -3,11 +3,12 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"regexp"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/sqlite"
@@ -26,38 +27,63 @@ func serveIndex(c *gin.Context) {
 	router.HandleContext(c)
 }
 
-func streamGameEvents(c *gin.Context) {
-	game, err := getVerifiedGame(c)
+func startNewGame(c *gin.Context) {
+	playerName, err := getVerifiedPlayerName(c)
 	if err != nil {
-		if err != err4xx {
-			log.Printf("failed to find verified game: %s", err)
-			c.AbortWithStatus(500)
-		}
 		return
 	}
-
-	clientChan := broker.NewClientChan(game.ID)
-	defer broker.RemoveClientChan(game.ID, clientChan)
-	c.Stream(func(w io.Writer) bool {
-		c.SSEvent(<-clientChan, "")
-		return true
-	})
-}
-
-func startNewGame(c *gin.Context) {
-	playerName, err := getVerifiedPlayerName(c)
+	var options struct {
+		RoomToken string `json:"room_token"`
+	}
+	settings := defaultGameSettings()
+	err = c.ShouldBindBodyWith(&settings, binding.JSON)
+	if err == nil {
+		err = c.ShouldBindBodyWith(&options, binding.JSON)
+	}
 	if err != nil {
+		c.JSON(400, gin.H{"error": "invalid settings"})
 		return
 	}
+	err = settings.validate()
+	if err != nil {
+		respondActionError(c, err, "startNewGame")
+		return
+	}
+	var roomID *uint64
+	if options.RoomToken != "" {
+		var room Room
+		err = db.First(&room, "token = ?", options.RoomToken).Error
+		if err == gorm.ErrRecordNotFound {
+			c.JSON(400, gin.H{"error": "invalid room_token"})
+			return
+		}
+		if err != nil {
+			log.Printf("failed to find room: %s", err)
+			c.AbortWithStatus(500)
+			return
+		}
+		roomID = &room.ID
+		if settings.CardSelection == "" {
+			// Rooms are meant for not seeing the same cards again:
+			settings.CardSelection = CARD_SELECTION_AVOID_SEEN
+		}
+	}
 
 	var game Game
 	var player Player
 	err = db.Transaction(func(tx *gorm.DB) error {
 		game = Game{
-			Token: generateToken(),
-			Round: 1,
+			Token:        generateToken(),
+			Round:        1,
+			Phase:        GAME_PHASE_WAIT_FOR_READY,
+			GameSettings: settings,
+			RoomID:       roomID,
 		}
-		err := tx.Create(&game).Error
+		err := assignJoinCode(tx, &game)
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&game).Error
 		if err != nil {
 			return err
 		}
@@ -68,21 +94,30 @@ func startNewGame(c *gin.Context) {
 			Name:  playerName,
 			Round: 0,
 		}
+		player.ProfileID, err = getProfileIDForNewPlayer(c, tx, game.ID)
+		if err != nil {
+			return err
+		}
 		err = tx.Create(&player).Error
 		if err != nil {
 			return err
 		}
-		return nil
+		err = appendGameEvent(tx, game, nil, GAME_EVENT_GAME_CREATED, nil)
+		if err != nil {
+			return err
+		}
+		return appendGameEvent(tx, game, &player.ID, GAME_EVENT_PLAYER_JOINED, gameEventPlayerJoined{
+			Name:      player.Name,
+			ProfileID: player.ProfileID,
+		})
 	})
 	if err != nil {
 		log.Printf("failed to create game or player: %s", err)
 		c.AbortWithStatus(500)
 		return
 	}
-	c.JSON(201, gin.H{
-		"game_token":   game.Token,
-		"player_token": player.Token,
-	})
+	publishLobby(game)
+	respondJoined(c, game, player)
 }
 
 func joinGame(c *gin.Context) {
@@ -100,22 +135,46 @@ func joinGame(c *gin.Context) {
 		return
 	}
 
-	phase, err := game.GetPhase()
-	if err != nil {
-		log.Printf("failed to getPhase: %s", err)
-		c.AbortWithStatus(500)
+	var options struct {
+		NextRound bool `json:"next_round"`
+	}
+	if err := c.ShouldBindBodyWith(&options, binding.JSON); err != nil {
+		c.JSON(400, gin.H{"error": "invalid next_round"})
 		return
 	}
-	if game.Round != 1 || phase != GAME_PHASE_WAIT_FOR_READY {
-		c.JSON(403, gin.H{"error": "cannot join after game start"})
+
+	player, err := addPlayer(c, game, playerName, options.NextRound)
+	if err != nil {
+		respondActionError(c, err, "addPlayer")
 		return
 	}
+	respondJoined(c, game, player)
+}
 
-	errNameAlreadyTaken := errors.New("name already taken")
+// addPlayer seats a new player in a game which hasn't started yet. With
+// nextRound, players may also join a running game and get queued for its
+// next round.
+func addPlayer(c *gin.Context, game Game, playerName string, nextRound bool) (Player, error) {
 	player := Player{
 		Name:   playerName,
 		GameID: game.ID,
 	}
+
+	// Joining must not overlap with the start of the game:
+	unlock := lockGame(game.ID)
+	defer unlock()
+	err := db.First(&game, game.ID).Error
+	if err != nil {
+		return player, fmt.Errorf("failed to reload game: %s", err)
+	}
+	started := game.Round != 1 || game.Phase != GAME_PHASE_WAIT_FOR_READY
+	if game.Phase == GAME_PHASE_GAME_OVER {
+		return player, newActionError(403, "game is over")
+	}
+	if started && !nextRound {
+		return player, newActionError(403, "cannot join after game start")
+	}
+
 	err = db.Transaction(func(tx *gorm.DB) error {
 		var num uint64
 		err := tx.Model(&player).Where(player).Count(&num).Error
@@ -123,38 +182,75 @@ func joinGame(c *gin.Context) {
 			return err
 		}
 		if num != 0 {
-			return errNameAlreadyTaken
+			return newActionError(400, "name already taken")
+		}
+		if started {
+			player.Queued = true
+			player.FirstRound = game.Round
+			if game.Phase != GAME_PHASE_WAIT_FOR_READY {
+				player.FirstRound++
+			}
+		}
+		if game.MaxPlayers != 0 {
+			err = tx.Model(&Player{}).Where("game_id = ?", game.ID).Count(&num).Error
+			if err != nil {
+				return err
+			}
+			if num >= uint64(game.MaxPlayers) {
+				return newActionError(409, "game is full")
+			}
 		}
 
 		player.Token = generateToken()
+		player.ProfileID, err = getProfileIDForNewPlayer(c, tx, game.ID)
+		if err != nil {
+			return err
+		}
 
 		err = tx.Create(&player).Error
 		if err != nil {
 			return err
 		}
-		return nil
+		return appendGameEvent(tx, game, &player.ID, GAME_EVENT_PLAYER_JOINED, gameEventPlayerJoined{
+			Name:      player.Name,
+			ProfileID: player.ProfileID,
+			Queued:    player.Queued,
+		})
 	})
-	if err == errNameAlreadyTaken {
-		c.JSON(400, gin.H{"error": "name already taken"})
-		return
-	}
 	if err != nil {
-		log.Printf("failed to create player: %s", err)
-		c.AbortWithStatus(500)
-		return
+		return player, err
 	}
 	broker.Send(game.ID, "players")
 	broker.Send(game.ID, "scoreboard")
-	c.JSON(201, gin.H{
-		"player_token": player.Token,
-	})
+	publishBoard(game.ID)
+	publishLobby(game)
+	return player, nil
+}
+
+func respondJoined(c *gin.Context, game Game, player Player) {
+	resp := gin.H{
+		"game_token": game.Token,
+	}
+	if game.JoinCode != nil {
+		resp["join_code"] = *game.JoinCode
+	}
+	if player.Queued {
+		resp["queued"] = true
+	}
+	if sessionCookies {
+		setSessionCookie(c, game, player)
+	} else {
+		resp["player_token"] = player.Token
+	}
+	c.JSON(201, resp)
 }
 
 func getVerifiedPlayerName(c *gin.Context) (string, error) {
 	var p struct {
 		Name string `json:"player_name" binding:"required"`
 	}
-	if err := c.BindJSON(&p); err != nil {
+	// Binding keeps the body for handlers which read further fields:
+	if err := c.ShouldBindBodyWith(&p, binding.JSON); err != nil {
 		c.JSON(400, gin.H{"error": "missing player_name"})
 		return "", err
 	}
@@ -181,17 +277,42 @@ func getVerifiedPlayer(c *gin.Context) (Player, error) {
 	if err != nil {
 		return player, err
 	}
-	err = db.First(&player, "token = ?", c.Param("player_token")).Error
-	if err == gorm.ErrRecordNotFound {
-		c.JSON(404, gin.H{"error": "invalid player_token"})
+	playerToken := c.Param("player_token")
+	if playerToken == "" {
+		var ok bool
+		playerToken, ok = getSessionPlayerToken(c, game.Token)
+		if !ok {
+			c.JSON(401, gin.H{"error": "missing or invalid session"})
+			return player, err4xx
+		}
+	}
+	player, err = findPlayer(game, playerToken)
+	if aerr, ok := err.(actionError); ok {
+		c.JSON(aerr.status, gin.H{"error": aerr.message})
 		return player, err4xx
 	}
 	if err != nil {
 		return player, err
 	}
+	if c.Param("player_token") != "" {
+		// Migrate URL token users to session cookies:
+		setSessionCookie(c, game, player)
+	}
+	return player, nil
+}
+
+// findPlayer looks up a player of the given game by token.
+func findPlayer(game Game, playerToken string) (Player, error) {
+	var player Player
+	err := db.First(&player, "token = ?", playerToken).Error
+	if err == gorm.ErrRecordNotFound {
+		return player, newActionError(404, "invalid player_token")
+	}
+	if err != nil {
+		return player, err
+	}
 	if game.ID != player.GameID {
-		c.JSON(400, gin.H{"error": "player_token does not match associated game"})
-		return player, err4xx
+		return player, newActionError(400, "player_token does not match associated game")
 	}
 	player.Game = game
 	return player, nil
@@ -207,157 +328,147 @@ func getPlayerList(c *gin.Context) {
 		return
 	}
 
-	var players []Player
-	err = db.Model(&game).Related(&players).Error
+	names, err := getPlayerNames(game)
 	if err != nil {
 		log.Printf("failed to query players: %s", err)
 		c.AbortWithStatus(500)
 		return
 	}
+	c.JSON(200, gin.H{
+		"players": names,
+	})
+}
+
+func getPlayerNames(game Game) ([]string, error) {
+	var players []Player
+	err := db.Model(&game).Related(&players).Error
+	if err != nil {
+		return nil, err
+	}
 	names := make([]string, len(players))
 	for i, player := range players {
 		names[i] = player.Name
 	}
-	c.JSON(200, gin.H{
-		"players": names,
-	})
+	return names, nil
 }
 
 func markPlayerReady(c *gin.Context) {
 	player, err := getVerifiedPlayer(c)
 	if err != nil {
 		if err != err4xx {
-			log.Printf("GetPhase failed: %s", err)
+			log.Printf("getVerifiedPlayer failed: %s", err)
 			c.AbortWithStatus(500)
 		}
 		return
 	}
 
-	phase, err := player.Game.GetPhase()
+	err = setPlayerReady(player)
 	if err != nil {
-		log.Printf("GetPhase failed: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-	if phase != GAME_PHASE_WAIT_FOR_READY {
-		c.JSON(403, nil)
+		respondActionError(c, err, "markPlayerReady")
 		return
 	}
+	c.JSON(200, nil)
+}
+
+// seatQueuedPlayers lets the players who have joined a running game take
+// part from the round which is starting.
+func seatQueuedPlayers(tx *gorm.DB, game *Game) error {
+	q := tx.Model(&Player{}).Where("game_id = ? AND queued = 1", game.ID)
+	return q.UpdateColumns(map[string]interface{}{
+		"queued":      false,
+		"round":       game.Round,
+		"first_round": game.Round,
+	}).Error
+}
 
-	player.Round = player.Game.Round
-	err = db.Save(&player).Error
+// startNewRound deals the cards for the game's current round.
+func startNewRound(tx *gorm.DB, game *Game) error {
+	err := seatQueuedPlayers(tx, game)
 	if err != nil {
-		log.Printf("markPlayerReady failed: %s", err)
-		c.AbortWithStatus(500)
-		return
+		return fmt.Errorf("startNewRound failed to seat queued players: %s", err)
 	}
+	var players []Player
+	err = tx.Model(&game).Related(&players).Error
+	if err != nil {
+		return fmt.Errorf("startNewRound failed to get players: %s", err)
+	}
+	var dealt gameEventRoundStarted
 
-	ready, err := player.Game.PlayersReady()
+	numCards := len(players) + game.NumDecoys(len(players))
+	cards, err := getCardSelector(game).SelectCards(tx, game, numCards)
 	if err != nil {
-		log.Printf("numNonReadyPlayers failed: %s", err)
-		c.AbortWithStatus(500)
-		return
+		return fmt.Errorf("failed to select cards: %s", err)
 	}
-	if ready {
-		// All players are ready, move to next round:
-		err = startNewRound(&player.Game)
-		if err != nil {
-			log.Printf("markPlayerReady failed startNewRound: %s", err)
-			c.AbortWithStatus(500)
-			return
-		}
+	if len(cards) != numCards {
+		return fmt.Errorf("failed to select cards: only %d of %d left", len(cards), numCards)
 	}
-	broker.Send(player.Game.ID, "board")
-	c.JSON(200, nil)
-}
-
-func startNewRound(game *Game) error {
-	err := db.Transaction(func(tx *gorm.DB) error {
-		var players []Player
-		err := tx.Model(&game).Related(&players).Error
-		if err != nil {
-			return fmt.Errorf("startNewRound failed to get players: %s", err)
-		}
-
-		assignCard := func(player *Player) error {
-			// Find unused card:
-			var card Card
-			q := tx.Table("cards")
-			q = q.Select("cards.*, COUNT(words_usage.card_id) as usage_count")
-			// Join the words table for calculating total card usage across all games:
-			q = q.Joins("LEFT JOIN words AS words_usage ON words_usage.card_id = cards.id")
-			// Join the words table a second time for finding out whether a card
-			// was already used in this game:
-			q = q.Joins("LEFT JOIN words ON words.card_id = cards.id AND words.game_id = ?", game.ID)
-			// Required for the COUNT():
-			q = q.Group("cards.id")
-			// Only include cards which haven't been used in this game:
-			q = q.Where("words.card_id IS NULL")
-			// Pre-sort randomly:
-			q = q.Order(gorm.Expr("random()"))
-			// Perform the final sort based on total usage count, i.e.
-			// prefer cards which have been used the least overall:
-			q = q.Order("usage_count")
-			// Pick one:
-			q = q.Limit(1)
-			err = q.First(&card).Error
-			if err != nil {
-				return fmt.Errorf("failed to assign card: %s", err)
-			}
-			// Save new word entry:
-			word := Word{
-				GameID:  game.ID,
-				Round:   game.Round,
-				CardID:  card.ID,
-				Letters: pickRandomLetters(),
-			}
-			if player != nil {
-				word.PlayerID = &player.ID
-			} // else it's NULL
 
-			err = tx.Save(&word).Error
-			if err != nil {
-				return fmt.Errorf("failed to save word entry: %s", err)
-			}
+	assignCard := func(player *Player, card Card) error {
+		// Save new word entry:
+		word := Word{
+			GameID:  game.ID,
+			Round:   game.Round,
+			CardID:  card.ID,
+			Letters: pickRandomLetters(game.GameSettings),
+		}
+		if player != nil {
+			word.PlayerID = &player.ID
+		} // else it's NULL
 
-			if player == nil {
-				return nil
-			}
+		err = tx.Save(&word).Error
+		if err != nil {
+			return fmt.Errorf("failed to save word entry: %s", err)
+		}
+		dealtCard := gameEventCard{
+			CardID:  word.CardID,
+			Letters: word.Letters,
+		}
+		if player != nil {
+			// Copy, as player points to the loop variable:
+			playerID := player.ID
+			dealtCard.PlayerID = &playerID
+		}
+		dealt.Cards = append(dealt.Cards, dealtCard)
 
-			// Assign new word entry:
-			player.Word = word
-			err = tx.Save(&player).Error
-			if err != nil {
-				return fmt.Errorf("failed to assign new word entry: %s", err)
-			}
+		if player == nil {
 			return nil
 		}
 
-		for _, player := range players {
-			err := assignCard(&player)
-			if err != nil {
-				return err
-			}
+		// Assign new word entry:
+		player.Word = word
+		err = tx.Save(&player).Error
+		if err != nil {
+			return fmt.Errorf("failed to assign new word entry: %s", err)
 		}
+		return nil
+	}
 
-		numAdditionalCards := len(players) + 1
-		if len(players) <= 5 {
-			numAdditionalCards = 6 - len(players)
-		} else {
-			numAdditionalCards = 1
+	for i, player := range players {
+		err := assignCard(&player, cards[i])
+		if err != nil {
+			return err
 		}
-		for x := 0; x < numAdditionalCards; x++ {
-			err := assignCard(nil)
-			if err != nil {
-				return err
-			}
+	}
+	for _, card := range cards[len(players):] {
+		err := assignCard(nil, card)
+		if err != nil {
+			return err
 		}
-		return nil
-	})
-	return err
+	}
+
+	cardIDs := make([]uint64, len(cards))
+	for i, card := range cards {
+		cardIDs[i] = card.ID
+	}
+	err = tx.Model(&Card{}).Where("id IN (?)", cardIDs).UpdateColumn("times_dealt", gorm.Expr("times_dealt + 1")).Error
+	if err != nil {
+		return fmt.Errorf("failed to count dealt cards: %s", err)
+	}
+	return appendGameEvent(tx, *game, nil, GAME_EVENT_ROUND_STARTED, dealt)
 }
 
 type jsonBoard struct {
+	Version         int64               `json:"version"`
 	Players         []jsonPlayer        `json:"players"`
 	Self            jsonSelf            `json:"self"`
 	Round           int64               `json:"round"`
@@ -376,28 +487,38 @@ type jsonCard struct {
 }
 
 type jsonPlayer struct {
-	ID                  uint64 `json:"id"`
-	Name                string `json:"name"`
-	IsReady             bool   `json:"is_ready"`
-	IsSelf              bool   `json:"is_self"`
-	Letters             string `json:"letters"`
-	Word                string `json:"word"`
-	ScoreTotal          uint64 `json:"score_total"`
-	ScoreOwnWords       uint64 `json:"score_own_words"`
-	ScoreCorrectGuesses uint64 `json:"score_correct_guesses"`
-	AllWordsAssigned    bool   `json:"all_words_assigned"`
+	ID                  uint64   `json:"id"`
+	Name                string   `json:"name"`
+	IsReady             bool     `json:"is_ready"`
+	IsSelf              bool     `json:"is_self"`
+	IsQueued            bool     `json:"is_queued"`
+	FirstRound          int64    `json:"first_round"`
+	Letters             string   `json:"letters"`
+	Tiles               []string `json:"tiles"`
+	Word                string   `json:"word"`
+	Jokers              []int    `json:"jokers"`
+	HasWord             bool     `json:"has_word"`
+	ScoreTotal          uint64   `json:"score_total"`
+	ScoreOwnWords       uint64   `json:"score_own_words"`
+	ScoreCorrectGuesses uint64   `json:"score_correct_guesses"`
+	AllWordsAssigned    bool     `json:"all_words_assigned"`
 }
 
 type jsonSelf struct {
-	Card    jsonCard `json:"card"`
-	Letters string   `json:"letters"`
-	IsReady bool     `json:"is_ready"`
-	Word    string   `json:"word"`
+	Card          jsonCard `json:"card"`
+	Letters       string   `json:"letters"`
+	Tiles         []string `json:"tiles"`
+	IsReady       bool     `json:"is_ready"`
+	IsQueued      bool     `json:"is_queued"`
+	Word          string   `json:"word"`
+	Jokers        []int    `json:"jokers"`
+	MulligansLeft int      `json:"mulligans_left"`
 }
 
 type jsonCurrentlyScored struct {
 	PlayerID uint64      `json:"player_id"`
 	Word     string      `json:"word"`
+	Jokers   []int       `json:"jokers"`
 	Guesses  jsonGuesses `json:"guesses"`
 }
 
@@ -433,16 +554,14 @@ func getBoard(c *gin.Context) {
 func getBoardJson(player Player) (jsonBoard, error) {
 	board := jsonBoard{}
 
+	board.Version = player.Game.Version
 	board.Round = player.Game.Round
-	var err error
-	board.Phase, err = player.Game.GetPhase()
-	if err != nil {
-		return board, err
-	}
+	board.Phase = player.Game.Phase
+	board.Self.IsQueued = player.Queued
 
 	var word Word
 	var card Card
-	err = db.Model(&player).Related(&word).Error
+	err := db.Model(&player).Related(&word).Error
 	if err == nil {
 		err = db.Model(&word).Related(&card).Error
 		if err != nil {
@@ -454,62 +573,38 @@ func getBoardJson(player Player) (jsonBoard, error) {
 			IsSelf:   true,
 			PlayerID: &player.ID,
 		}
+		board.Self.MulligansLeft, err = getMulligansLeft(db, player, word)
+		if err != nil {
+			return board, err
+		}
 	} else if err != nil && err != gorm.ErrRecordNotFound {
 		return board, err
 	}
 
-	scoreboardOrder, scoreByPlayer, err := getScoreByPlayers(player.Game.ID)
+	board.ScoreboardOrder, board.Players, err = getBoardPlayers(player.Game, player.ID)
 	if err != nil {
 		return board, err
 	}
-	board.ScoreboardOrder = scoreboardOrder
-
-	var players []Player
-	err = db.Model(&player.Game).Related(&players).Error
-	if err != nil {
-		return board, err
-	}
-
-	wordsAssignedByPlayer, err := getWordsAssignedByPlayers(player.Game.ID, len(players))
-	if err != nil {
-		return board, err
-	}
-
-	for _, otherPlayer := range players {
-		var word Word
-		err = db.Model(&otherPlayer).Related(&word).Error
-		if err != nil && err != gorm.ErrRecordNotFound {
-			return board, err
-		}
-		isReady := otherPlayer.Round >= player.Game.Round
-		board.Players = append(board.Players, jsonPlayer{
-			ID:                  otherPlayer.ID,
-			Name:                otherPlayer.Name,
-			IsReady:             isReady,
-			IsSelf:              otherPlayer.ID == player.ID,
-			Letters:             word.Letters,
-			Word:                word.Word,
-			ScoreTotal:          scoreByPlayer[otherPlayer.ID].ScoreTotal,
-			ScoreOwnWords:       scoreByPlayer[otherPlayer.ID].ScoreOwnWords,
-			ScoreCorrectGuesses: scoreByPlayer[otherPlayer.ID].ScoreCorrectGuesses,
-			AllWordsAssigned:    wordsAssignedByPlayer[otherPlayer.ID],
-		})
-		if otherPlayer.ID == player.ID {
-			board.Self.IsReady = isReady
-			board.Self.Letters = word.Letters
-			board.Self.Word = word.Word
+	for _, p := range board.Players {
+		if p.IsSelf {
+			board.Self.IsReady = p.IsReady
+			board.Self.Letters = p.Letters
+			board.Self.Tiles = p.Tiles
+			board.Self.Word = p.Word
+			board.Self.Jokers = p.Jokers
 		}
 	}
 
 	var words []struct {
-		CardID   uint64
-		CardText string
-		PlayerID *uint64
-		IsScored bool
-		Score    uint64
+		CardID       uint64
+		CardText     string
+		PlayerID     *uint64
+		IsScored     bool
+		Score        uint64
+		JokerPenalty uint64
 	}
 	q := db.Table("words")
-	q = q.Select("cards.id AS card_id, cards.text AS card_text, words.player_id AS player_id, words.is_scored AS is_scored, (COUNT(my_correct_guesses.id) + COUNT(correct_guesses_own_word.id)) AS score")
+	q = q.Select("cards.id AS card_id, cards.text AS card_text, words.player_id AS player_id, words.is_scored AS is_scored, (COUNT(my_correct_guesses.id) + COUNT(correct_guesses_own_word.id)) AS score, words.joker_penalty AS joker_penalty")
 	q = q.Joins("LEFT JOIN cards ON words.card_id = cards.id LEFT JOIN guesses AS my_correct_guesses ON my_correct_guesses.word_id = words.id AND my_correct_guesses.card_id = words.card_id AND my_correct_guesses.player_id = ? AND words.is_scored = 1", player.ID)
 	q = q.Joins("LEFT JOIN guesses AS correct_guesses_own_word ON correct_guesses_own_word.word_id = words.id AND words.player_id = ? AND correct_guesses_own_word.card_id = words.card_id AND words.is_scored = 1", player.ID)
 	q = q.Where("words.game_id = ?", player.Game.ID)
@@ -528,12 +623,24 @@ func getBoardJson(player Player) (jsonBoard, error) {
 			Text:   word.CardText,
 			IsSelf: word.CardID == board.Self.Card.ID,
 		}
-		if c.IsSelf || word.IsScored {
+		var ownerID uint64
+		if word.PlayerID != nil {
+			ownerID = *word.PlayerID
+		}
+		if isCardOwnerVisible(getViewerRole(player.ID, ownerID), word.IsScored) {
 			c.PlayerID = word.PlayerID
 		}
 		if word.IsScored {
 			// Create a copy which we can safely reference:
 			score := word.Score
+			if c.IsSelf {
+				// Jokers cost points of the own word only:
+				if score > word.JokerPenalty {
+					score -= word.JokerPenalty
+				} else {
+					score = 0
+				}
+			}
 			c.Score = &score
 		} else {
 			c.Score = nil
@@ -541,20 +648,87 @@ func getBoardJson(player Player) (jsonBoard, error) {
 		board.Cards = append(board.Cards, c)
 	}
 	if board.Phase == GAME_PHASE_SCORE {
-		word, err := getCurrentlyScoredWord(player.Game)
+		board.CurrentlyScored, err = getCurrentlyScoredJson(player.Game)
 		if err != nil {
 			return board, err
 		}
-		board.CurrentlyScored.Word = word.Word
-		board.CurrentlyScored.PlayerID = *word.PlayerID
-		board.CurrentlyScored.Guesses = make(jsonGuesses, 0)
-		for _, guess := range word.Guesses {
-			board.CurrentlyScored.Guesses[guess.PlayerID] = guess.CardID
-		}
 	}
 	return board, nil
 }
 
+// getBoardPlayers lists the players along with what everybody may know
+// about them. selfID marks the requesting player, if any.
+func getBoardPlayers(game Game, selfID uint64) ([]uint64, []jsonPlayer, error) {
+	scoreboardOrder, scoreByPlayer, err := getScoreByPlayers(game.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var players []Player
+	err = db.Model(&game).Related(&players).Error
+	if err != nil {
+		return nil, nil, err
+	}
+	numSeated := 0
+	for _, p := range players {
+		if !p.Queued {
+			numSeated++
+		}
+	}
+
+	wordsAssignedByPlayer, err := getWordsAssignedByPlayers(game.ID, numSeated)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	boardPlayers := make([]jsonPlayer, 0, len(players))
+	for _, otherPlayer := range players {
+		var word Word
+		err = db.Model(&otherPlayer).Related(&word).Error
+		if err != nil && err != gorm.ErrRecordNotFound {
+			return nil, nil, err
+		}
+		tiles := parseTiles(word.Letters)
+		boardPlayer := jsonPlayer{
+			ID:                  otherPlayer.ID,
+			Name:                otherPlayer.Name,
+			IsReady:             otherPlayer.Round >= game.Round,
+			IsSelf:              otherPlayer.ID == selfID,
+			IsQueued:            otherPlayer.Queued,
+			FirstRound:          otherPlayer.FirstRound,
+			Letters:             strings.Join(tiles, ""),
+			Tiles:               tiles,
+			Word:                word.Word,
+			Jokers:              parseJokers(word.Jokers),
+			HasWord:             word.Word != "",
+			ScoreTotal:          scoreByPlayer[otherPlayer.ID].ScoreTotal,
+			ScoreOwnWords:       scoreByPlayer[otherPlayer.ID].ScoreOwnWords,
+			ScoreCorrectGuesses: scoreByPlayer[otherPlayer.ID].ScoreCorrectGuesses,
+			AllWordsAssigned:    wordsAssignedByPlayer[otherPlayer.ID],
+		}
+		role := getViewerRole(selfID, otherPlayer.ID)
+		hidePlayerFields(&boardPlayer, game.Phase, role)
+		boardPlayers = append(boardPlayers, boardPlayer)
+	}
+	return scoreboardOrder, boardPlayers, nil
+}
+
+func getCurrentlyScoredJson(game Game) (jsonCurrentlyScored, error) {
+	var currentlyScored jsonCurrentlyScored
+	word, err := getCurrentlyScoredWord(game)
+	if err != nil {
+		return currentlyScored, err
+	}
+	currentlyScored.Word = word.Word
+	currentlyScored.Jokers = parseJokers(word.Jokers)
+	currentlyScored.PlayerID = *word.PlayerID
+	currentlyScored.Guesses = make(jsonGuesses, 0)
+	for _, guess := range word.Guesses {
+		currentlyScored.Guesses[guess.PlayerID] = guess.CardID
+	}
+	return currentlyScored, nil
+}
+
 func getCurrentlyScoredWord(game Game) (Word, error) {
 	var word Word
 	var numPlayers int64
@@ -588,61 +762,20 @@ func submitWord(c *gin.Context) {
 		return
 	}
 
-	phase, err := player.Game.GetPhase()
-	if err != nil {
-		log.Printf("GetPhase failed: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-	if phase != GAME_PHASE_SUBMIT_WORD {
-		c.JSON(403, nil)
-		return
-	}
-	var word Word
-	err = db.Model(player).Related(&word).Error
-	if err != nil {
-		return
-	}
-
 	var w struct {
-		Word string `json:"word" binding:"required"`
+		Word   string `json:"word" binding:"required"`
+		Jokers []int  `json:"jokers"`
 	}
 	if err := c.BindJSON(&w); err != nil {
 		c.JSON(400, gin.H{"error": "no word submitted"})
 		return
 	}
 
-	if len([]rune(w.Word)) > WORD_NUM_LETTERS_TOTAL {
-		c.JSON(400, gin.H{"error": "too many letters"})
-		return
-	}
-
-	availableLetters := word.Letters
-	for _, wl := range w.Word {
-		found := false
-		for i, al := range availableLetters {
-			if wl == al {
-				// Remove from availableLetters to prevent duplicate usage:
-				availableLetters = availableLetters[:i] + availableLetters[i+1:]
-				found = true
-				break
-			}
-		}
-		if !found {
-			c.JSON(400, gin.H{"error": "invalid letter"})
-			return
-		}
-	}
-
-	word.Word = w.Word
-	err = db.Save(&word).Error
+	err = saveWord(player, w.Word, w.Jokers)
 	if err != nil {
-		log.Printf("failed to save word: %s", err)
-		c.AbortWithStatus(500)
+		respondActionError(c, err, "submitWord")
 		return
 	}
-
-	broker.Send(player.Game.ID, "board")
 	c.JSON(200, nil)
 }
 
@@ -656,16 +789,6 @@ func submitGuesses(c *gin.Context) {
 		return
 	}
 
-	phase, err := player.Game.GetPhase()
-	if err != nil {
-		log.Printf("GetPhase failed: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-	if phase != GAME_PHASE_ASSIGN_WORDS {
-		c.JSON(403, nil)
-		return
-	}
 	var guesses struct {
 		Guesses jsonGuesses `json:"guesses" binding:"required"`
 	}
@@ -674,100 +797,11 @@ func submitGuesses(c *gin.Context) {
 		return
 	}
 
-	var numOtherPlayers int
-	q := db.Table("players")
-	q = q.Where("game_id = ?", player.GameID)
-	q = q.Where("id <> ?", player.ID)
-	q = q.Count(&numOtherPlayers)
-	err = q.Error
-	if err != nil {
-		log.Printf("failed to get number of other players: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-	if len(guesses.Guesses) != numOtherPlayers {
-		c.JSON(400, gin.H{"error": "bad number of guesses"})
-		return
-	}
-	for playerID, _ := range guesses.Guesses {
-		if playerID == player.ID {
-			c.JSON(403, gin.H{"error": "attempting to guess own word"})
-			return
-		}
-	}
-
-	errCardUsed := errors.New("card already used")
-	errInvalidCard := errors.New("invalid card")
-	err = db.Transaction(func(tx *gorm.DB) error {
-		err := tx.Where(Guess{
-			GameID:   player.Game.ID,
-			Round:    player.Game.Round,
-			PlayerID: player.ID,
-		}).Delete(Guess{}).Error
-		if err != nil {
-			return err
-		}
-		usedCards := make(map[uint64]bool, 0)
-		for playerID, cardID := range guesses.Guesses {
-			if _, exists := usedCards[cardID]; exists {
-				return errCardUsed
-			}
-			var word Word
-			err = tx.Where(Word{
-				GameID:   player.Game.ID,
-				Round:    player.Game.Round,
-				PlayerID: &playerID,
-			}).First(&word).Error
-			if err != nil {
-				return err
-			}
-
-			var validCard uint64
-			err = tx.Table("words").Where(&Word{
-				GameID: player.Game.ID,
-				Round:  player.Game.Round,
-				CardID: cardID,
-			}).Count(&validCard).Error
-			if err != nil {
-				return err
-			}
-			if validCard != 1 {
-				return errInvalidCard
-			}
-
-			err = tx.Save(&Guess{
-				GameID:   player.Game.ID,
-				Round:    player.Game.Round,
-				PlayerID: player.ID,
-				WordID:   word.ID,
-				CardID:   cardID,
-			}).Error
-			if err != nil {
-				return err
-			}
-			usedCards[cardID] = true
-		}
-		return nil
-	})
-	if err == gorm.ErrRecordNotFound {
-		c.JSON(400, gin.H{"error": "player not resolvable to word"})
-		return
-	}
-	if err == errInvalidCard {
-		c.JSON(400, gin.H{"error": err.Error()})
-		return
-	}
-	if err == errCardUsed {
-		c.JSON(400, gin.H{"error": "duplicate card use"})
-		return
-	}
+	err = saveGuesses(player, guesses.Guesses)
 	if err != nil {
-		log.Printf("submitGuesses failed: %v", err)
-		c.AbortWithStatus(500)
+		respondActionError(c, err, "submitGuesses")
 		return
 	}
-
-	broker.Send(player.Game.ID, "board")
 	c.JSON(200, nil)
 }
 
@@ -781,13 +815,7 @@ func getGuesses(c *gin.Context) {
 		return
 	}
 
-	phase, err := player.Game.GetPhase()
-	if err != nil {
-		log.Printf("GetPhase failed: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-	if phase != GAME_PHASE_ASSIGN_WORDS {
+	if player.Game.Phase != GAME_PHASE_ASSIGN_WORDS {
 		c.JSON(403, nil)
 		return
 	}
@@ -813,7 +841,7 @@ func getGuesses(c *gin.Context) {
 	c.JSON(200, gin.H{"guesses": g})
 }
 
-func markScored(c *gin.Context) {
+func takeMulligan(c *gin.Context) {
 	player, err := getVerifiedPlayer(c)
 	if err != nil {
 		if err != err4xx {
@@ -823,56 +851,29 @@ func markScored(c *gin.Context) {
 		return
 	}
 
-	phase, err := player.Game.GetPhase()
+	err = redrawLetters(player)
 	if err != nil {
-		log.Printf("GetPhase failed: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-	if phase != GAME_PHASE_SCORE {
-		c.JSON(403, gin.H{"error": "wrong game phase"})
+		respondActionError(c, err, "takeMulligan")
 		return
 	}
+	c.JSON(200, nil)
+}
 
-	word, err := getCurrentlyScoredWord(player.Game)
-	if err == gorm.ErrRecordNotFound {
-		c.JSON(403, gin.H{"error": "no scoring in progress"})
-		return
-	}
+func markScored(c *gin.Context) {
+	player, err := getVerifiedPlayer(c)
 	if err != nil {
-		log.Printf("getCurrentlyScoredWord failed: %s", err)
-		c.AbortWithStatus(500)
-		return
-	}
-
-	if word.PlayerID != nil && *word.PlayerID != player.ID {
-		c.JSON(403, gin.H{"error": "not your turn"})
+		if err != err4xx {
+			log.Printf("failed to get verified player: %s", err)
+			c.AbortWithStatus(500)
+		}
 		return
 	}
 
-	word.IsScored = true
-
-	err = db.Save(&word).Error
+	err = scoreCurrentWord(player)
 	if err != nil {
-		log.Printf("saving word failed: %s", err)
-		c.AbortWithStatus(500)
+		respondActionError(c, err, "markScored")
 		return
 	}
-
-	word, err = getCurrentlyScoredWord(player.Game)
-	if err == gorm.ErrRecordNotFound {
-		game := player.Game
-		game.Round++
-		err := db.Save(&game).Error
-		if err != nil {
-			log.Printf("failed to save new round number: %s", err)
-			c.AbortWithStatus(500)
-			return
-		}
-	}
-
-	broker.Send(player.Game.ID, "board")
-	broker.Send(player.Game.ID, "scoreboard")
 	c.JSON(200, nil)
 }
 
@@ -888,14 +889,8 @@ func getScoreByPlayers(gameID uint64) ([]uint64, map[uint64]scoreByPlayer, error
 	resultsByPlayer := make(map[uint64]scoreByPlayer, 0)
 	var resultOrder []uint64
 	var results []scoreByPlayer
-	q := db.Table("players")
-	q = q.Select("players.id AS player_id, players.name, COUNT(DISTINCT correct_guesses_words.id) AS score_correct_guesses, COUNT(DISTINCT own_words_guesses.id) AS score_own_words, (COUNT(DISTINCT correct_guesses_words.id) + COUNT(DISTINCT own_words_guesses.id)) AS score_total")
-	q = q.Joins("LEFT JOIN guesses AS correct_guesses ON correct_guesses.player_id = players.id")
-	q = q.Joins("LEFT JOIN words AS correct_guesses_words ON correct_guesses.word_id = correct_guesses_words.id AND correct_guesses_words.is_scored = 1 AND correct_guesses.card_id = correct_guesses_words.card_id")
-	q = q.Joins("LEFT JOIN words AS own_words ON own_words.player_id = players.id")
-	q = q.Joins("LEFT JOIN guesses AS own_words_guesses ON own_words.id = own_words_guesses.word_id AND own_words.card_id = own_words_guesses.card_id AND own_words.is_scored = 1")
+	q := selectPlayerScores()
 	q = q.Where("players.game_id = ?", gameID)
-	q = q.Group("players.id")
 	q = q.Order("score_total DESC, score_own_words DESC, score_correct_guesses DESC, players.id")
 	q = q.Scan(&results)
 	err := q.Error
@@ -909,6 +904,19 @@ func getScoreByPlayers(gameID uint64) ([]uint64, map[uint64]scoreByPlayer, error
 	return resultOrder, resultsByPlayer, nil
 }
 
+// selectPlayerScores queries the scores of players as scoreByPlayer along
+// with their game_id. The caller picks the games.
+func selectPlayerScores() *gorm.DB {
+	// Own words score a point per correct guess, less what their jokers
+	// cost, but never less than zero:
+	ownWordsScore := "COALESCE((SELECT SUM(MAX(0, (SELECT COUNT(*) FROM guesses WHERE guesses.word_id = own_words.id AND guesses.card_id = own_words.card_id) - own_words.joker_penalty)) FROM words AS own_words WHERE own_words.player_id = players.id AND own_words.is_scored = 1), 0)"
+	q := db.Table("players")
+	q = q.Select("players.id AS player_id, players.game_id, players.name, COUNT(DISTINCT correct_guesses_words.id) AS score_correct_guesses, " + ownWordsScore + " AS score_own_words, (COUNT(DISTINCT correct_guesses_words.id) + " + ownWordsScore + ") AS score_total")
+	q = q.Joins("LEFT JOIN guesses AS correct_guesses ON correct_guesses.player_id = players.id")
+	q = q.Joins("LEFT JOIN words AS correct_guesses_words ON correct_guesses.word_id = correct_guesses_words.id AND correct_guesses_words.is_scored = 1 AND correct_guesses.card_id = correct_guesses_words.card_id")
+	return q.Group("players.id")
+}
+
 func getWordsAssignedByPlayers(gameID uint64, numPlayers int) (map[uint64]bool, error) {
 	resultsByPlayer := make(map[uint64]bool, 0)
 	var results []struct {
@@ -918,7 +926,7 @@ func getWordsAssignedByPlayers(gameID uint64, numPlayers int) (map[uint64]bool,
 	q := db.Table("players")
 	q = q.Select("players.*, (COUNT(guesses.id) == ?) AS all_words_assigned", numPlayers-1)
 	q = q.Joins("LEFT JOIN guesses ON guesses.game_id = players.game_id AND guesses.round = players.round AND guesses.player_id = players.id")
-	q = q.Where("players.game_id = ?", gameID)
+	q = q.Where("players.game_id = ? AND players.queued = 0", gameID)
 	q = q.Group("players.id, guesses.player_id")
 	q = q.Scan(&results)
 	err := q.Error
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const (
+	// WORD_MIN_LENGTH is the default of GameSettings.MinWordLength.
+	WORD_MIN_LENGTH = 2
+	// WORD_MIN_BANNED_LENGTH is the shortest word of a card's text which
+	// must not be used as a word. Shorter ones are too common to ban.
+	WORD_MIN_BANNED_LENGTH = 3
+	// WORD_MIN_STEM_LENGTH is the shortest stem which a word must not
+	// share with a word of the card's text. Shorter ones, like "und" or
+	// "der", start too many unrelated words.
+	WORD_MIN_STEM_LENGTH = 4
+
+	// WORD_ERROR_* are the error codes of words which break the game's
+	// rules, see checkWordRules. Clients rely on them, so they must not
+	// change.
+	WORD_ERROR_TOO_SHORT     = "word_too_short"
+	WORD_ERROR_NO_VOWEL      = "word_without_vowel"
+	WORD_ERROR_IN_CARD_TEXT  = "word_from_card_text"
+	WORD_ERROR_IN_DICTIONARY = "word_from_dictionary"
+)
+
+// wordErrorMessages are the messages of the WORD_ERROR_* codes.
+var wordErrorMessages = map[string]string{
+	WORD_ERROR_TOO_SHORT:     "word too short",
+	WORD_ERROR_NO_VOWEL:      "word without vowel",
+	WORD_ERROR_IN_CARD_TEXT:  "word from card text",
+	WORD_ERROR_IN_DICTIONARY: "word from dictionary",
+}
+
+// dictionaries holds the real words of the game languages for which a
+// word list exists, normalized by wordLetters.
+var dictionaries = make(map[string]map[string]bool)
+
+// loadDictionaries reads <dictionaryPath>/<language>.txt for every game
+// language. Languages without a word list don't support
+// GameSettings.BanDictionaryWords.
+func loadDictionaries() error {
+	for language := range gameLanguages {
+		dictionary, err := readDictionary(filepath.Join(dictionaryPath, language+".txt"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dictionaries[language] = dictionary
+	}
+	return nil
+}
+
+// readDictionary parses a word list with one word per line.
+func readDictionary(path string) (map[string]bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	dictionary := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		word := wordLetters(scanner.Text())
+		if word != "" {
+			dictionary[word] = true
+		}
+	}
+	return dictionary, scanner.Err()
+}
+
+// wordLetters upper-cases the word and drops everything but letters, so
+// that neither case nor spaces get a word past the rules.
+func wordLetters(word string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, word)
+}
+
+// checkWordRules checks a word which has been laid with the player's
+// tiles against the rules of the game. The error is meant for the client
+// and carries one of the WORD_ERROR_* codes.
+func checkWordRules(settings GameSettings, word string, cardText string) error {
+	letters := wordLetters(word)
+	if len([]rune(letters)) < settings.MinWordLength {
+		return newWordError(WORD_ERROR_TOO_SHORT)
+	}
+	if settings.RequireVowel && !strings.ContainsAny(letters, tileSets[settings.Language].vowels()) {
+		return newWordError(WORD_ERROR_NO_VOWEL)
+	}
+	if settings.BanCardWords && isInCardText(letters, cardText) {
+		return newWordError(WORD_ERROR_IN_CARD_TEXT)
+	}
+	if settings.BanDictionaryWords && dictionaries[settings.Language][letters] {
+		return newWordError(WORD_ERROR_IN_DICTIONARY)
+	}
+	return nil
+}
+
+func newWordError(code string) error {
+	return newCodedActionError(400, code, wordErrorMessages[code])
+}
+
+// isInCardText tells whether the letters are one of the card text's
+// words or share its stem, e.g. "HAUS" or "HAUSE" for "Haus am See" and
+// "FEUER" for "Feuerwehr". Words which merely contain a card word, like
+// "WUNDERBAR" for "Katz und Maus", are fine.
+func isInCardText(letters string, cardText string) bool {
+	words := strings.FieldsFunc(cardText, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for _, cardWord := range words {
+		cardWord = wordLetters(cardWord)
+		if cardWord == letters && len([]rune(letters)) >= WORD_MIN_BANNED_LENGTH {
+			return true
+		}
+		stem := letters
+		if len(cardWord) < len(stem) {
+			stem = cardWord
+		}
+		if len([]rune(stem)) >= WORD_MIN_STEM_LENGTH && strings.HasPrefix(letters, stem) && strings.HasPrefix(cardWord, stem) {
+			return true
+		}
+	}
+	return false
+}
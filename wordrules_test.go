@@ -0,0 +1,114 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// wordErrorCode returns the WORD_ERROR_* code of a checkWordRules error.
+func wordErrorCode(err error) string {
+	if aerr, ok := err.(actionError); ok {
+		return aerr.code
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return ""
+}
+
+func TestCheckWordRules(t *testing.T) {
+	set, err := readTileSet("tiles/de.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tileSets["de"] = set
+	dictionaries["de"] = map[string]bool{"HAUS": true}
+	defer delete(dictionaries, "de")
+
+	settings := defaultGameSettings()
+	settings.BanDictionaryWords = true
+	card := "Ein Haus am See"
+	tests := []struct {
+		word string
+		err  string
+	}{
+		{"BLORK", ""},
+		{"BÜ", ""},
+		{"B", WORD_ERROR_TOO_SHORT},
+		{"B\u00a0\u00a0", WORD_ERROR_TOO_SHORT},
+		{"BRMPF", WORD_ERROR_NO_VOWEL},
+		{"BRMPF\u00a0Y", ""},
+		{"HAUSE", WORD_ERROR_IN_CARD_TEXT},
+		{"HAU", ""},
+		{"AUS", ""},
+		{"EIN", WORD_ERROR_IN_CARD_TEXT},
+		{"SEE", WORD_ERROR_IN_CARD_TEXT},
+		{"H\u00a0AUS", WORD_ERROR_IN_CARD_TEXT},
+		// Short card words only count as a whole:
+		{"AM", ""},
+		{"SEEL", ""},
+		{"EINHORN", ""},
+		{"KLEINE", ""},
+	}
+	for _, test := range tests {
+		err := checkWordRules(settings, test.word, card)
+		if wordErrorCode(err) != test.err {
+			t.Errorf("checkWordRules(%q) = %v, want %q", test.word, err, test.err)
+		}
+	}
+
+	for _, word := range []string{"FEUER", "WEHR", "FEUERWEHREN"} {
+		err = checkWordRules(settings, word, "Feuerwehr")
+		want := WORD_ERROR_IN_CARD_TEXT
+		if word == "WEHR" {
+			want = ""
+		}
+		if wordErrorCode(err) != want {
+			t.Errorf("checkWordRules(%q) = %v for Feuerwehr, want %q", word, err, want)
+		}
+	}
+	for _, word := range []string{"WUNDERBAR", "DERBY", "MITTAG", "UNDINE"} {
+		if err = checkWordRules(settings, word, "Der Hund mit dem Knochen und das Kind von nebenan"); err != nil {
+			t.Errorf("checkWordRules(%q) = %v for a card with stop words", word, err)
+		}
+	}
+
+	err = checkWordRules(settings, "Haus", "Ein Boot")
+	if wordErrorCode(err) != WORD_ERROR_IN_DICTIONARY {
+		t.Errorf("dictionary word was accepted: %v", err)
+	}
+	settings.BanDictionaryWords = false
+	if err = checkWordRules(settings, "HAUS", "Ein Boot"); err != nil {
+		t.Errorf("dictionary word was rejected without dictionary rule: %v", err)
+	}
+	settings.MinWordLength = 1
+	settings.RequireVowel = false
+	settings.BanCardWords = false
+	for _, word := range []string{"B", "BRMPF", "HAUSE"} {
+		if err = checkWordRules(settings, word, card); err != nil {
+			t.Errorf("checkWordRules(%q) = %v without rules", word, err)
+		}
+	}
+}
+
+func TestReadDictionary(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dictionary")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "de.txt")
+	err = ioutil.WriteFile(path, []byte("Haus\n\nstraßen-bahn\r\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dictionary, err := readDictionary(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dictionary) != 2 || !dictionary["HAUS"] || !dictionary[wordLetters("Straßenbahn")] {
+		t.Errorf("unexpected dictionary %v", dictionary)
+	}
+}
@@ -0,0 +1,260 @@
+package main
+
+// The WebSocket endpoint carries the same events as the SSE streams as
+// well as the player commands, all as JSON messages.
+//
+// Client to server:
+//   {"id": 1, "type": "auth", "player_token": "..."}
+//   {"id": 2, "type": "ready"}
+//   {"id": 3, "type": "word", "word": "...", "jokers": [<index>, ...]}
+//   {"id": 4, "type": "guesses", "guesses": {"<player id>": <card id>, ...}}
+//   {"id": 5, "type": "scored"}
+//   {"id": 6, "type": "mulligan"}
+// jokers lists the positions of the word's letters which were played as
+// jokers and may be left out. Players with a session cookie are
+// authenticated right away.
+//
+// Server to client:
+//   {"type": "event", "id": <event id>, "event": "board", "data": {...}}
+//   {"type": "event", "id": <event id>, "event": "mulligan",
+//    "data": {"player_id": <player id>, "name": "...", "round": <round>}}
+//   {"type": "result", "id": <command id>, "status": 200}
+//   {"type": "result", "id": <command id>, "status": 403, "error": "..."}
+//   {"type": "result", "id": <command id>, "status": 400, "error": "...", "code": "..."}
+// Rejected words come with one of the WORD_ERROR_* codes.
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	WS_PING_INTERVAL = 30 * time.Second
+	WS_PONG_TIMEOUT  = 60 * time.Second
+	WS_WRITE_TIMEOUT = 10 * time.Second
+)
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+type wsCommand struct {
+	ID          uint64      `json:"id"`
+	Type        string      `json:"type"`
+	PlayerToken string      `json:"player_token"`
+	Word        string      `json:"word"`
+	Jokers      []int       `json:"jokers"`
+	Guesses     jsonGuesses `json:"guesses"`
+}
+
+type wsMessage struct {
+	Type   string          `json:"type"`
+	ID     uint64          `json:"id,omitempty"`
+	Event  string          `json:"event,omitempty"`
+	Data   json.RawMessage `json:"data,omitempty"`
+	Status int             `json:"status,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Code   string          `json:"code,omitempty"`
+}
+
+type wsSession struct {
+	conn   *websocket.Conn
+	game   Game
+	player *Player
+	client *BrokerClient
+}
+
+func serveGameWebSocket(c *gin.Context) {
+	game, err := getVerifiedGame(c)
+	if err != nil {
+		if err != err4xx {
+			log.Printf("failed to find verified game: %s", err)
+			c.AbortWithStatus(500)
+		}
+		return
+	}
+
+	ws := &wsSession{game: game}
+	if token, ok := getSessionPlayerToken(c, game.Token); ok {
+		player, err := findPlayer(game, token)
+		if err == nil {
+			ws.player = &player
+		}
+	}
+
+	ws.conn, err = wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// Upgrade has already responded to the client.
+		return
+	}
+	defer ws.conn.Close()
+	ws.run()
+}
+
+func (ws *wsSession) run() {
+	ws.subscribe()
+	defer func() {
+		broker.Unsubscribe(ws.game.ID, ws.client)
+	}()
+
+	// done stops the reader from waiting for run to take a command after
+	// run has returned:
+	done := make(chan struct{})
+	defer close(done)
+	commands := make(chan wsCommand)
+	go ws.readCommands(commands, done)
+
+	ping := time.NewTicker(WS_PING_INTERVAL)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-ws.client.Notify():
+			for _, e := range ws.client.Drain() {
+				err = ws.writeEvent(e)
+				if err != nil {
+					break
+				}
+			}
+		case cmd, ok := <-commands:
+			if !ok {
+				return
+			}
+			err = ws.write(ws.handle(cmd))
+		case <-ping.C:
+			deadline := time.Now().Add(WS_WRITE_TIMEOUT)
+			err = ws.conn.WriteControl(websocket.PingMessage, nil, deadline)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// subscribe (re-)registers the session with the broker and sends the
+// current state, as the subscription depends on the authenticated player.
+func (ws *wsSession) subscribe() {
+	if ws.client != nil {
+		broker.Unsubscribe(ws.game.ID, ws.client)
+	}
+	var playerID uint64
+	initial := []string{"players", "scoreboard", "public_board"}
+	if ws.player != nil {
+		playerID = ws.player.ID
+		initial = []string{"board"}
+	}
+	ws.client, _ = broker.Subscribe(ws.game.ID, playerID, 0)
+	id := broker.LastEventID(ws.game.ID)
+	for _, name := range initial {
+		if e, ok := broker.Render(ws.game.ID, playerID, name); ok {
+			e.id = id
+			ws.writeEvent(e)
+		}
+	}
+}
+
+func (ws *wsSession) readCommands(commands chan<- wsCommand, done <-chan struct{}) {
+	defer close(commands)
+	ws.conn.SetReadDeadline(time.Now().Add(WS_PONG_TIMEOUT))
+	ws.conn.SetPongHandler(func(string) error {
+		ws.conn.SetReadDeadline(time.Now().Add(WS_PONG_TIMEOUT))
+		return nil
+	})
+	for {
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		var cmd wsCommand
+		if err := json.Unmarshal(data, &cmd); err != nil {
+			cmd = wsCommand{Type: "invalid"}
+		}
+		select {
+		case commands <- cmd:
+		case <-done:
+			return
+		}
+	}
+}
+
+// handle runs a command with the same validation as the REST handlers.
+func (ws *wsSession) handle(cmd wsCommand) wsMessage {
+	result := wsMessage{Type: "result", ID: cmd.ID, Status: 200}
+	err := ws.execute(cmd)
+	if aerr, ok := err.(actionError); ok {
+		result.Status = aerr.status
+		result.Error = aerr.message
+		result.Code = aerr.code
+	} else if err != nil {
+		log.Printf("websocket command %s failed: %s", cmd.Type, err)
+		result.Status = 500
+	}
+	return result
+}
+
+func (ws *wsSession) execute(cmd wsCommand) error {
+	if cmd.Type == "auth" {
+		player, err := findPlayer(ws.game, cmd.PlayerToken)
+		if err != nil {
+			return err
+		}
+		ws.player = &player
+		ws.subscribe()
+		return nil
+	}
+	if cmd.Type == "invalid" {
+		return newActionError(400, "invalid command")
+	}
+	if ws.player == nil {
+		return newActionError(401, "missing or invalid session")
+	}
+
+	// The game has most likely changed since the connection was opened:
+	var player Player
+	err := db.First(&player, ws.player.ID).Error
+	if err != nil {
+		return err
+	}
+	err = db.First(&player.Game, player.GameID).Error
+	if err != nil {
+		return err
+	}
+
+	switch cmd.Type {
+	case "ready":
+		return setPlayerReady(player)
+	case "word":
+		if cmd.Word == "" {
+			return newActionError(400, "no word submitted")
+		}
+		return saveWord(player, cmd.Word, cmd.Jokers)
+	case "guesses":
+		if cmd.Guesses == nil {
+			return newActionError(400, "missing guesses")
+		}
+		return saveGuesses(player, cmd.Guesses)
+	case "scored":
+		return scoreCurrentWord(player)
+	case "mulligan":
+		return redrawLetters(player)
+	}
+	return newActionError(400, "unknown command")
+}
+
+func (ws *wsSession) writeEvent(e brokerEvent) error {
+	msg := wsMessage{Type: "event", ID: e.id, Event: e.name}
+	if e.data != "" {
+		msg.Data = json.RawMessage(e.data)
+	}
+	return ws.write(msg)
+}
+
+func (ws *wsSession) write(msg wsMessage) error {
+	ws.conn.SetWriteDeadline(time.Now().Add(WS_WRITE_TIMEOUT))
+	return ws.conn.WriteJSON(msg)
+}